@@ -0,0 +1,94 @@
+package sqldump
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestCompressionPath(t *testing.T) {
+	d, err := NewDumper(nil, os.TempDir(), "test_dump.sql")
+	if err != nil {
+		t.Fatalf("Error creating dumper: %s", err.Error())
+	}
+
+	base := filepath.Join(os.TempDir(), "test_dump.sql")
+	if d.Path() != base {
+		t.Fatalf("expected %#v, got %#v", base, d.Path())
+	}
+
+	if err := d.SetCompression(Gzip, 9); err != nil {
+		t.Fatalf("Error setting compression: %s", err.Error())
+	}
+
+	if d.Path() != base+".gz" {
+		t.Fatalf("expected %#v, got %#v", base+".gz", d.Path())
+	}
+
+	if err := d.SetCompression(Zstd, 0); err != nil {
+		t.Fatalf("Error setting compression: %s", err.Error())
+	}
+
+	if d.Path() != base+".zst" {
+		t.Fatalf("expected %#v, got %#v", base+".zst", d.Path())
+	}
+
+	if err := d.SetCompression(Gzip, 42); err == nil {
+		t.Fatalf("expected an error for an invalid gzip level")
+	}
+}
+
+func TestCompressor(t *testing.T) {
+	input := []byte("INSERT INTO test VALUES (1),(2),(3);\n")
+	d := NewStreamDumper(nil)
+
+	for _, c := range []Compression{NoCompression, Gzip, Zstd} {
+		if err := d.SetCompression(c, 3); err != nil {
+			t.Fatalf("Error setting compression: %s", err.Error())
+		}
+
+		var buf bytes.Buffer
+		w, err := d.compressor(&buf)
+		if err != nil {
+			t.Fatalf("Error creating compressor: %s", err.Error())
+		}
+
+		w.Write(input)
+		if err := w.Close(); err != nil {
+			t.Fatalf("Error closing compressor: %s", err.Error())
+		}
+
+		var output []byte
+		switch c {
+		case NoCompression:
+			output = buf.Bytes()
+		case Gzip:
+			r, err := gzip.NewReader(&buf)
+			if err != nil {
+				t.Fatalf("Error reading gzip stream: %s", err.Error())
+			}
+			if output, err = io.ReadAll(r); err != nil {
+				t.Fatalf("Error reading gzip stream: %s", err.Error())
+			}
+		case Zstd:
+			r, err := zstd.NewReader(&buf)
+			if err != nil {
+				t.Fatalf("Error reading zstd stream: %s", err.Error())
+			}
+			output, err = io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				t.Fatalf("Error reading zstd stream: %s", err.Error())
+			}
+		}
+
+		if !bytes.Equal(output, input) {
+			t.Fatalf("compression %d: expected %#v, got %#v", c, string(input), string(output))
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package sqldump
+
+import (
+	"strings"
+)
+
+// Content selects whether a dump holds the structure of the database, its data, or both.
+type Content int
+
+const (
+	// SchemaAndData dumps the structure and the rows of tables. This is the default.
+	SchemaAndData Content = iota
+	// SchemaOnly dumps tables and other schema objects without their rows.
+	SchemaOnly
+	// DataOnly dumps the rows to load into existing tables, without any DDL.
+	// PostgreSQL sequence values are part of the data.
+	DataOnly
+)
+
+// SetContent sets whether the structure, the data or both are dumped.
+func (d *Dumper) SetContent(c Content) {
+	d.content = c
+}
+
+// SetDropTables sets whether tables are dropped before they are created. This is the default.
+func (d *Dumper) SetDropTables(drop bool) {
+	d.keepTables = !drop
+}
+
+// SetCreateIfNotExists creates tables, and their PostgreSQL sequences, indexes and constraints,
+// only if they don't exist yet. Combine with SetDropTables(false) to restore into an existing schema.
+func (d *Dumper) SetCreateIfNotExists(ifNotExists bool) {
+	d.ifNotExists = ifNotExists
+}
+
+// SetTruncate empties tables before their data is restored.
+// A PostgreSQL dump truncates all its tables in one statement before any data, as tables referenced
+// by foreign keys can only be truncated together with the tables referring to them.
+func (d *Dumper) SetTruncate(truncate bool) {
+	d.truncate = truncate
+}
+
+// createIfNotExists adds IF NOT EXISTS to a CREATE statement of one of the given kinds, if SetCreateIfNotExists is used.
+func (d *Dumper) createIfNotExists(stmt string, kinds ...string) string {
+	if !d.ifNotExists {
+		return stmt
+	}
+
+	for _, kind := range kinds {
+		prefix := "CREATE " + kind + " "
+		if strings.HasPrefix(stmt, prefix) {
+			return prefix + "IF NOT EXISTS " + stmt[len(prefix):]
+		}
+	}
+	return stmt
+}
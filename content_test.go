@@ -0,0 +1,165 @@
+package sqldump
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCreateIfNotExists(t *testing.T) {
+	d := NewStreamDumper(nil)
+	if result := d.createIfNotExists("CREATE TABLE a (x int)", "TABLE"); result != "CREATE TABLE a (x int)" {
+		t.Errorf("expected the statement unchanged by default, got %#v", result)
+	}
+
+	d.SetCreateIfNotExists(true)
+	cases := map[string]string{
+		"CREATE TABLE a (x int)":             "CREATE TABLE IF NOT EXISTS a (x int)",
+		"CREATE UNIQUE INDEX i ON a (x)":     "CREATE UNIQUE INDEX IF NOT EXISTS i ON a (x)",
+		"CREATE INDEX i ON a USING btree(x)": "CREATE INDEX IF NOT EXISTS i ON a USING btree(x)",
+		"CREATE VIEW v AS SELECT 1":          "CREATE VIEW v AS SELECT 1",
+	}
+
+	for stmt, expected := range cases {
+		if result := d.createIfNotExists(stmt, "TABLE", "INDEX", "UNIQUE INDEX"); result != expected {
+			t.Errorf("expected %#v, got %#v", expected, result)
+		}
+	}
+}
+
+func TestDumpMySQLDataOnly(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectQuery("^SELECT version()").WillReturnRows(sqlmock.NewRows([]string{"Version()"}).AddRow("test_version"))
+	mock.ExpectQuery("^SELECT @@max_allowed_packet$").WillReturnRows(maxPacketRows())
+	mock.ExpectQuery("^SHOW FULL TABLES$").WillReturnRows(fullTablesRows("t"))
+	mock.ExpectQuery("FROM information_schema.STATISTICS").WithArgs("t").WillReturnRows(noKeyRows())
+	mock.ExpectQuery("^SELECT (.+) FROM `t`$").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	dumper := NewStreamDumper(db)
+	dumper.SetContent(DataOnly)
+	dumper.SetTruncate(true)
+	var buf bytes.Buffer
+	if err := dumper.DumpTo(&buf); err != nil {
+		t.Fatalf("Error while dumping the database: %s", err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	result := buf.String()
+	expected := "TRUNCATE TABLE `t`;\nLOCK TABLES `t` WRITE;\n/*!40000 ALTER TABLE `t` DISABLE KEYS */;\n\nINSERT INTO `t` VALUES ('1');\n"
+	if !strings.Contains(result, expected) {
+		t.Errorf("expected output to contain %#v, got %#v", expected, result)
+	}
+
+	if strings.Contains(result, "CREATE TABLE") || strings.Contains(result, "DROP TABLE") {
+		t.Errorf("expected no DDL, got %#v", result)
+	}
+}
+
+func TestDumpMySQLSchemaOnly(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectQuery("^SELECT version()").WillReturnRows(sqlmock.NewRows([]string{"Version()"}).AddRow("test_version"))
+	mock.ExpectQuery("^SELECT @@max_allowed_packet$").WillReturnRows(maxPacketRows())
+	mock.ExpectQuery("^SHOW FULL TABLES$").WillReturnRows(fullTablesRows("t"))
+	mock.ExpectQuery("FROM information_schema.TRIGGERS").WillReturnRows(noTriggerRows())
+	mock.ExpectQuery("^SHOW CREATE TABLE `t`$").WillReturnRows(sqlmock.NewRows([]string{"Table", "Create Table"}).
+		AddRow("t", "CREATE TABLE `t` (`id` int(11) NOT NULL)"))
+
+	dumper := NewStreamDumper(db)
+	dumper.SetContent(SchemaOnly)
+	dumper.SetDropTables(false)
+	dumper.SetCreateIfNotExists(true)
+	var buf bytes.Buffer
+	if err := dumper.DumpTo(&buf); err != nil {
+		t.Fatalf("Error while dumping the database: %s", err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	result := buf.String()
+	if !strings.Contains(result, "CREATE TABLE IF NOT EXISTS `t` (`id` int(11) NOT NULL);") {
+		t.Errorf("expected CREATE TABLE IF NOT EXISTS, got %#v", result)
+	}
+
+	for _, s := range []string{"DROP TABLE", "LOCK TABLES", "INSERT"} {
+		if strings.Contains(result, s) {
+			t.Errorf("expected output without %#v, got %#v", s, result)
+		}
+	}
+}
+
+func TestPostgresContentTemplates(t *testing.T) {
+	tbl := &table{
+		Name:        "public.a",
+		SQL:         "CREATE TABLE IF NOT EXISTS public.a ()",
+		Constraints: []string{"a_pkey PRIMARY KEY (id)"},
+		Indexes:     []string{"CREATE INDEX IF NOT EXISTS a_x ON public.a USING btree (x)"},
+	}
+
+	data := dump{Structure: true, Data: true, IfNotExists: true, Truncate: true, Table: tbl}
+	var buf bytes.Buffer
+	for _, tpl := range []string{pgtableheader, pgtablesql, pgindexes} {
+		if err := template.Must(template.New("").Funcs(templateFuncs).Parse(tpl)).Execute(&buf, data); err != nil {
+			t.Fatalf("error was not expected while executing the template: %s", err)
+		}
+	}
+
+	expected := []string{
+		"CREATE TABLE IF NOT EXISTS public.a ()",
+		"DO $$ BEGIN\nALTER TABLE ONLY public.a ADD CONSTRAINT a_pkey PRIMARY KEY (id);\n" +
+			"EXCEPTION WHEN duplicate_object OR duplicate_table OR invalid_table_definition THEN NULL;\nEND $$",
+		"CREATE INDEX IF NOT EXISTS a_x ON public.a USING btree (x)",
+	}
+	if result := splitAll(t, buf.String(), true); !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+
+	// Data only
+	buf.Reset()
+	data = dump{Data: true, Truncate: true, Table: tbl}
+	for _, tpl := range []string{pgtableheader, pgtablesql} {
+		if err := template.Must(template.New("").Funcs(templateFuncs).Parse(tpl)).Execute(&buf, data); err != nil {
+			t.Fatalf("error was not expected while executing the template: %s", err)
+		}
+	}
+
+	if result := splitAll(t, buf.String(), true); len(result) != 0 {
+		t.Fatalf("expected no statements, got %#v", result)
+	}
+}
+
+func TestPgTruncate(t *testing.T) {
+	names := []string{"public.a", `public."it's"`}
+	if result := splitAll(t, pgTruncate(names, false), true); !reflect.DeepEqual(result, []string{`TRUNCATE TABLE ONLY public.a, public."it's"`}) {
+		t.Errorf("expected one TRUNCATE of both tables, got %#v", result)
+	}
+
+	expected := []string{"DO $$ DECLARE names text; BEGIN\n" +
+		"SELECT pg_catalog.string_agg(n, ', ') INTO names FROM pg_catalog.unnest(ARRAY['public.a', 'public.\"it''s\"']) n\n" +
+		"WHERE pg_catalog.to_regclass(n) IS NOT NULL;\n" +
+		"IF names IS NOT NULL THEN EXECUTE 'TRUNCATE TABLE ONLY ' || names; END IF;\n" +
+		"END $$"}
+	if result := splitAll(t, pgTruncate(names, true), true); !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %#v, got %#v", expected, result)
+	}
+}
@@ -0,0 +1,259 @@
+package sqldump
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DataFormat selects how table data is written.
+type DataFormat int
+
+const (
+	// InsertData writes rows as extended INSERT statements. This is the default.
+	InsertData DataFormat = iota
+	// CopyData writes PostgreSQL table data as COPY ... FROM stdin blocks, like pg_dump, which restore much faster.
+	// MySQL/MariaDB dumps always use INSERT statements.
+	CopyData
+)
+
+// SetDataFormat sets how table data is written.
+func (d *Dumper) SetDataFormat(f DataFormat) {
+	d.format = f
+}
+
+// CopyToFunc runs a COPY ... TO STDOUT query on a driver connection, as passed to (*sql.Conn).Raw,
+// and writes the data to w.
+type CopyToFunc func(ctx context.Context, driverConn interface{}, w io.Writer, query string) error
+
+// SetCopyTo lets COPY data be read by the driver, as database/sql has no support for COPY TO STDOUT.
+// Without it, rows are read with SELECT and converted to the COPY text format by the dumper.
+// With pgx it can be set up like this:
+//
+//	dumper.SetCopyTo(func(ctx context.Context, dc interface{}, w io.Writer, query string) error {
+//		_, err := dc.(*stdlib.Conn).Conn().PgConn().CopyTo(ctx, w, query)
+//		return err
+//	})
+func (d *Dumper) SetCopyTo(fn CopyToFunc) {
+	d.copyTo = fn
+}
+
+// copyData reports whether table data is written as COPY blocks.
+func (d *Dumper) copyData() bool {
+	return d.pg && d.format == CopyData
+}
+
+// copyTable writes the data of a table in the COPY text format, for the given columns if any.
+func (d *Dumper) copyTable(ctx context.Context, w io.Writer, name string, columns []string) error {
+	if d.copyTo != nil && d.conn != nil {
+		// Every row of COPY data is a line, so the rows are counted by the lines written.
+		cw := &countingWriter{w: w}
+		err := d.conn.Raw(func(dc interface{}) error {
+			query := "COPY " + name + " TO STDOUT"
+			if columns != nil {
+				query = "COPY " + name + " (" + selectColumns(columns) + ") TO STDOUT"
+			}
+			if q, ok := d.queries[name]; ok {
+				query = "COPY (SELECT " + selectColumns(columns) + " FROM ONLY " + name + q.clauses() + ") TO STDOUT"
+			}
+			return d.copyTo(ctx, dc, cw, query)
+		})
+		if err == nil {
+			d.observe(Event{Kind: BatchWritten, Table: name, Rows: cw.lines, Bytes: cw.bytes})
+		}
+		return err
+	}
+
+	return d.createTableValues(ctx, name, columns, func(values string) error {
+		_, err := io.WriteString(w, values)
+		return err
+	})
+}
+
+var copyEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r")
+
+// copyValue returns a value in the COPY text format.
+func copyValue(v sql.NullString, kind valueKind) string {
+	if !v.Valid {
+		return `\N`
+	}
+
+	if kind == kindBinary {
+		// The bytea hex format, with its backslash escaped.
+		return `\\x` + hex.EncodeToString([]byte(v.String))
+	}
+	return copyEscaper.Replace(v.String)
+}
+
+// unescapeCopy decodes a field of COPY text data.
+func unescapeCopy(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		switch c := s[i]; c {
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case 'x':
+			j := i + 1
+			for j < len(s) && j < i+3 && isHex(s[j]) {
+				j++
+			}
+
+			if j == i+1 {
+				b.WriteByte(c)
+				continue
+			}
+			n, _ := strconv.ParseUint(s[i+1:j], 16, 8)
+			b.WriteByte(byte(n))
+			i = j - 1
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			j := i
+			for j < len(s) && j < i+3 && s[j] >= '0' && s[j] <= '7' {
+				j++
+			}
+
+			n, _ := strconv.ParseUint(s[i:j], 8, 8)
+			b.WriteByte(byte(n))
+			i = j - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+var copyFromStdinRE = regexp.MustCompile(`(?is)^COPY\s+(.+?)\s+FROM\s+STDIN\b`)
+
+// copyReader reads the data of a COPY ... FROM stdin statement from a dump, up to the terminating \. line.
+type copyReader struct {
+	s       *statementScanner
+	started bool
+	buf     string
+	err     error
+}
+
+func (c *copyReader) Read(p []byte) (int, error) {
+	for c.buf == "" {
+		if c.err != nil {
+			return 0, c.err
+		}
+
+		line, err := c.s.readLine()
+		switch {
+		case err != nil && err != io.EOF:
+			c.err = err
+		case !c.started:
+			// The data starts on the line after the statement.
+			c.started = true
+			if err == io.EOF {
+				c.err = io.ErrUnexpectedEOF
+			}
+		case strings.TrimRight(line, "\r\n") == `\.`:
+			c.err = io.EOF
+		case err == io.EOF:
+			c.buf, c.err = line, io.ErrUnexpectedEOF
+		default:
+			c.buf = line
+		}
+	}
+
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// copyBatchRows is the number of rows per INSERT when COPY data is restored without driver support.
+const copyBatchRows = 1000
+
+// copyInserts restores COPY data with INSERT statements, for drivers without COPY support.
+// Values are written as string literals, which the server converts to the column types.
+// OVERRIDING SYSTEM VALUE keeps the values of GENERATED ALWAYS identity columns, and is accepted for any table.
+func copyInserts(ctx context.Context, conn *sql.Conn, stmt string, data io.Reader) error {
+	m := copyFromStdinRE.FindStringSubmatch(stmt)
+	if m == nil {
+		return errors.New("Unsupported COPY statement.")
+	}
+
+	var batch strings.Builder
+	rows := 0
+	flush := func() error {
+		if rows == 0 {
+			return nil
+		}
+
+		_, err := conn.ExecContext(ctx, "INSERT INTO "+m[1]+" OVERRIDING SYSTEM VALUE VALUES "+batch.String())
+		batch.Reset()
+		rows = 0
+		return err
+	}
+
+	r := bufio.NewReader(data)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		// Every row ends with a newline, so an empty last line is no row.
+		if err == nil || line != "" {
+			fields := strings.Split(strings.TrimSuffix(line, "\n"), "\t")
+			if rows > 0 {
+				batch.WriteByte(',')
+			}
+
+			batch.WriteByte('(')
+			for i, f := range fields {
+				if i > 0 {
+					batch.WriteByte(',')
+				}
+
+				if f == `\N` {
+					batch.WriteString("null")
+				} else {
+					batch.WriteString(quoteString(unescapeCopy(f), true))
+				}
+			}
+			batch.WriteByte(')')
+			rows++
+		}
+
+		if err == io.EOF {
+			return flush()
+		}
+
+		if rows >= copyBatchRows {
+			if err = flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
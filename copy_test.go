@@ -0,0 +1,190 @@
+package sqldump
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCopyValue(t *testing.T) {
+	cases := []struct {
+		value    sql.NullString
+		kind     valueKind
+		expected string
+	}{
+		{sql.NullString{}, kindString, `\N`},
+		{sql.NullString{String: `\N`, Valid: true}, kindString, `\\N`},
+		{sql.NullString{String: "a\tb\nc\rd\\e", Valid: true}, kindString, `a\tb\nc\rd\\e`},
+		{sql.NullString{String: "\x00\xff", Valid: true}, kindBinary, `\\x00ff`},
+		{sql.NullString{String: "42", Valid: true}, kindNumber, "42"},
+	}
+
+	for _, c := range cases {
+		if result := copyValue(c.value, c.kind); result != c.expected {
+			t.Errorf("expected %#v, got %#v", c.expected, result)
+		}
+
+		if c.value.Valid && c.kind == kindString {
+			if result := unescapeCopy(copyValue(c.value, c.kind)); result != c.value.String {
+				t.Errorf("expected %#v after unescaping, got %#v", c.value.String, result)
+			}
+		}
+	}
+
+	if result := unescapeCopy(`\101\x42\q\v`); result != "ABq\v" {
+		t.Errorf("expected %#v, got %#v", "ABq\v", result)
+	}
+}
+
+func TestCreateTableValuesCopy(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectQuery("FROM pg_catalog.pg_index").WithArgs("public.a").WillReturnRows(noKeyRows())
+	mock.ExpectQuery("^SELECT \\* FROM ONLY public.a$").WillReturnRows(sqlmock.NewRowsWithColumnDefinition(
+		sqlmock.NewColumn("id").OfType("INT4", int64(0)),
+		sqlmock.NewColumn("s").OfType("TEXT", ""),
+		sqlmock.NewColumn("b").OfType("BYTEA", []byte{})).
+		AddRow(1, "tab\there", []byte{1}).
+		AddRow(2, nil, nil))
+
+	d := NewStreamDumper(db)
+	d.pg = true
+	d.SetDataFormat(CopyData)
+	var buf bytes.Buffer
+	if err := d.copyTable(context.Background(), &buf, "public.a", nil); err != nil {
+		t.Fatalf("error was not expected while copying: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	expected := "1\ttab\\there\t\\\\x01\n2\t\\N\t\\N\n"
+	if buf.String() != expected {
+		t.Fatalf("expected %#v, got %#v", expected, buf.String())
+	}
+}
+
+func TestCopyTo(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	d := NewStreamDumper(db)
+	d.pg = true
+	d.SetDataFormat(CopyData)
+	d.SetCopyTo(func(ctx context.Context, dc interface{}, w io.Writer, query string) error {
+		if dc == nil || query != "COPY public.a TO STDOUT" {
+			t.Errorf("unexpected COPY of %#v on %#v", query, dc)
+		}
+		_, err := io.WriteString(w, "1\tx\n")
+		return err
+	})
+
+	if d.conn, err = db.Conn(context.Background()); err != nil {
+		t.Fatalf("error was not expected while pinning a connection: %s", err)
+	}
+
+	defer d.conn.Close()
+	var buf bytes.Buffer
+	if err := d.copyTable(context.Background(), &buf, "public.a", nil); err != nil {
+		t.Fatalf("error was not expected while copying: %s", err)
+	}
+
+	if buf.String() != "1\tx\n" {
+		t.Fatalf("unexpected COPY data %#v", buf.String())
+	}
+}
+
+const copyDump = "SET standard_conforming_strings = on;\n" +
+	"COPY public.a FROM stdin;\n" +
+	"1\tit's\ta\\tb\n" +
+	"\\N\tx\\\\y\t\n" +
+	"\\.\n" +
+	"SELECT 1;\n"
+
+func TestSplitCopy(t *testing.T) {
+	expected := []string{
+		"SET standard_conforming_strings = on",
+		"COPY public.a FROM stdin",
+		"SELECT 1",
+	}
+
+	result := splitAll(t, copyDump, true)
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+}
+
+func TestRestoreCopyInserts(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT version()").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("PostgreSQL 14.5"))
+	mock.ExpectExec("SET standard_conforming_strings = on").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO public.a OVERRIDING SYSTEM VALUE VALUES ('1','it''s','a	b'),(null,'x\y','')`).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("SELECT 1").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err = Restore(context.Background(), db, strings.NewReader(copyDump)); err != nil {
+		t.Fatalf("error was not expected while restoring: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestRestoreCopyFrom(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT version()").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("PostgreSQL 14.5"))
+	mock.ExpectExec("SET standard_conforming_strings = on").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SELECT 1").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	var data string
+	r := NewRestorer(db)
+	r.SetCopyFrom(func(ctx context.Context, dc interface{}, rd io.Reader, query string) error {
+		if query != "COPY public.a FROM stdin" {
+			t.Errorf("unexpected COPY statement %#v", query)
+		}
+
+		b, err := io.ReadAll(rd)
+		data = string(b)
+		return err
+	})
+
+	if err = r.Restore(context.Background(), strings.NewReader(copyDump)); err != nil {
+		t.Fatalf("error was not expected while restoring: %s", err)
+	}
+
+	if expected := "1\tit's\ta\\tb\n\\N\tx\\\\y\t\n"; data != expected {
+		t.Fatalf("expected %#v, got %#v", expected, data)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
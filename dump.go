@@ -1,139 +1,547 @@
 package sqldump
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"strings"
 )
 
 type table struct {
 	Name      string
-	Sequences string
+	Sequences []*sequence
 	SQL       string
 	Values    string
+	// Override is set for PostgreSQL tables with GENERATED ALWAYS identity columns.
+	Override bool
+	// Partitioned is set for PostgreSQL partitioned tables, whose rows are in their partitions.
+	Partitioned bool
+	// Attach adds a PostgreSQL partition to its partitioned table.
+	Attach string
+	// Columns are the quoted columns with data, if some are left out: PostgreSQL generated columns get their values on restore.
+	Columns []string
+	// Constraints, Indexes and ForeignKeys of PostgreSQL tables are added after the data.
+	Constraints []string
+	Indexes     []string
+	ForeignKeys []string
+	// NoData is set for tables dumped without their rows.
+	NoData bool
+	// Selection describes the rows dumped when a TableQuery is set.
+	Selection string
 }
 
 type dump struct {
-	file          *os.File
+	out           io.Writer
+	views         []string
+	objects       []*object
+	noData        map[string]bool
 	DumpVersion   string
 	ServerVersion string
-	Tables        []*table
 	Table         *table
+	Object        *object
+	Sequence      *sequence
 	CompleteTime  string
+	// Structure and Data are set when DDL and rows are dumped, as chosen by SetContent.
+	Structure   bool
+	Data        bool
+	Drop        bool
+	IfNotExists bool
+	Truncate    bool
 }
 
+// ErrCanceled is returned, along with the context's error, when a dump is stopped by its context.
+var ErrCanceled = errors.New("Dump canceled")
+
 // Dump a MySQL/MariaDB or PostgreSQL database or selection of tables from same based on the options supplied through the dumper.
+// The dump is written to the file returned by Path().
 func (d *Dumper) Dump(filters ...string) error {
-	// Check dump directory
-	if e, _ := exists(d.path); e {
-		return errors.New("Dump '" + d.path + "' already exists.")
+	return d.DumpContext(context.Background(), filters...)
+}
+
+// DumpContext is like Dump, but stops when ctx is done, with an error matching both ErrCanceled
+// and the context's error with errors.Is.
+//
+// The dump is written to a temporary file in the same directory, which is synced and moved
+// to Path() only when the dump is complete, and removed on any error.
+func (d *Dumper) DumpContext(ctx context.Context, filters ...string) error {
+	if d.path == "" {
+		return errors.New("No dump path set; use DumpTo instead.")
+	}
+
+	d.file = ""
+	path, err := d.targetPath()
+	if err != nil {
+		return err
+	}
+
+	f, err := createTemp(path)
+	if err != nil {
+		return err
+	}
+
+	// The file is hashed as it is written, for the manifest.
+	var out io.Writer = f
+	var hw *hashWriter
+	if d.manifest {
+		hw = newHashWriter(f)
+		out = hw
+	}
+
+	err = d.DumpToContext(ctx, out, filters...)
+	if err == nil {
+		err = commitTemp(f, path, d.overwrite)
+	}
+
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	d.file = path
+	if d.manifest {
+		err = d.writeManifest(hw)
 	}
+	return err
+}
+
+// DumpTo writes a dump of a MySQL/MariaDB or PostgreSQL database or selection of tables to w.
+// The output is compressed if SetCompression was used.
+func (d *Dumper) DumpTo(w io.Writer, filters ...string) error {
+	return d.DumpToContext(context.Background(), w, filters...)
+}
 
-	// Create dump file
-	f, err := os.Create(d.path)
+// DumpToContext is like DumpTo, but stops when ctx is done, with an error matching ErrCanceled.
+// Every query of the dump is run with ctx.
+func (d *Dumper) DumpToContext(ctx context.Context, w io.Writer, filters ...string) error {
+	cw, err := d.compressor(w)
 	if err != nil {
 		return err
 	}
 
-	defer f.Close()
+	d.startProgress()
+
+	err = d.dumpTo(ctx, cw, filters...)
+	if cerr := cw.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("%w: %w", ErrCanceled, ctx.Err())
+	}
+
+	d.observe(Event{Kind: DumpFinished, Err: err})
+	return err
+}
+
+func (d *Dumper) dumpTo(ctx context.Context, w io.Writer, filters ...string) (err error) {
 	data := dump{
-		file:        f,
+		out:         w,
 		DumpVersion: version,
-		Tables:      make([]*table, 0),
+		Structure:   d.content != DataOnly,
+		Data:        d.content != SchemaOnly,
+		Drop:        !d.keepTables,
+		IfNotExists: d.ifNotExists,
+		Truncate:    d.truncate && d.content != SchemaOnly,
 	}
 
 	list := filters
 	// Get server version, thereby identifying type.
-	if data.ServerVersion, err = d.getServerVersion(); err != nil {
+	if data.ServerVersion, err = d.getServerVersion(ctx); err != nil {
 		return err
 	}
 
-	if strings.Contains(data.ServerVersion, "PostgreSQL") {
-		d.pg = true
-		if len(list) == 0 {
-			list, err = d.getPostgresTables()
-			if err != nil {
+	if d.progress != nil {
+		d.progress.serverVersion = data.ServerVersion
+	}
+
+	d.pg = strings.Contains(data.ServerVersion, "PostgreSQL")
+	d.batchBytes = d.insertBytes
+	if d.batchBytes == 0 && !d.pg {
+		if d.batchBytes, err = d.getMySQLMaxPacket(ctx); err != nil {
+			return err
+		}
+	}
+
+	if d.usesSession() {
+		if err = d.beginSession(ctx, ""); err != nil {
+			return err
+		}
+
+		defer func() {
+			// The session is ended even if the dump was canceled, so no transaction is left open.
+			if serr := d.endSession(context.WithoutCancel(ctx)); err == nil {
+				err = serr
+			}
+		}()
+	}
+
+	if d.pg {
+		tables, err := d.getPostgresTables(ctx)
+		if err != nil {
+			return err
+		}
+
+		if err = d.resolveTableQueries(tables, nil); err != nil {
+			return err
+		}
+
+		var views []*object
+		if d.objects&Views != 0 || len(list) > 0 {
+			if views, err = d.getPostgresViews(ctx); err != nil {
 				return err
 			}
 		}
 
-		return d.DumpPostgres(data, list...)
+		if len(list) == 0 {
+			list = make([]string, len(tables))
+			for i, t := range tables {
+				list[i] = t.Qualified
+			}
+		} else if list, views, err = selectPostgres(list, tables, views); err != nil {
+			return err
+		}
+
+		if d.filtersTables() {
+			list, views, data.noData = d.filterPostgres(list, tables, views)
+		}
+
+		// Inherited tables and partitions are created after their parents, and partitioned tables have no rows of their own.
+		parents, partitioned, err := d.getPostgresInheritance(ctx)
+		if err != nil {
+			return err
+		}
+
+		list = parentsFirst(list, parents)
+		if len(partitioned) > 0 && data.noData == nil {
+			data.noData = map[string]bool{}
+		}
+		for name := range partitioned {
+			data.noData[name] = true
+		}
+
+		if d.objects&Views != 0 {
+			data.objects = views
+		}
+
+		d.startTables(list)
+		return d.DumpPostgres(ctx, data, list...)
+	}
+
+	tables, views, err := d.getMySQLTables(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = d.resolveTableQueries(nil, tables); err != nil {
+		return err
 	}
 
 	if len(list) == 0 {
-		list, err = d.getMySQLTables()
-		if err != nil {
+		list = tables
+		data.views = views
+	} else {
+		list, data.views = splitViews(list, views)
+	}
+
+	if d.filtersTables() {
+		var database string
+		if err = d.querier().QueryRowContext(ctx, "SELECT DATABASE()").Scan(&database); err != nil {
 			return err
 		}
+
+		list, data.views, data.noData = d.filterMySQL(database, list, data.views)
 	}
 
-	return d.DumpMySQL(data, filters...)
+	d.startTables(list)
+	return d.DumpMySQL(ctx, data, list...)
 }
 
-func (d *Dumper) getServerVersion() (string, error) {
+func (d *Dumper) getServerVersion(ctx context.Context) (string, error) {
 	var serverversion sql.NullString
-	if err := d.db.QueryRow("SELECT version()").Scan(&serverversion); err != nil {
+	if err := d.querier().QueryRowContext(ctx, "SELECT version()").Scan(&serverversion); err != nil {
 		return "", err
 	}
 	return serverversion.String, nil
 }
 
-func (d *Dumper) createTableValues(name string, offset, max int64) (string, error) {
-	// Get Data
-	if max == 0 {
-		max = 1000
+// ColumnList returns the list of columns the data is written for, or nothing if it covers all columns.
+func (t *table) ColumnList() string {
+	if t.Columns == nil {
+		return ""
 	}
+	return " (" + strings.Join(t.Columns, ", ") + ")"
+}
+
+// selectColumns returns the select list which reads columns, or all columns if none are given.
+func selectColumns(columns []string) string {
+	if columns == nil {
+		return "*"
+	}
+	return strings.Join(columns, ", ")
+}
 
-	var rows *sql.Rows
+// createTableValues reads the rows of a table and calls fn with the values for each INSERT statement.
+// Tables with a primary key, or a unique index on non-null columns, are read in pages ordered by that key,
+// each page starting after the last key of the previous one.
+// Tables without a usable key, or with an ORDER BY or limit set by SetTableQuery, are read with a single query.
+// Only the given columns are read, if any.
+func (d *Dumper) createTableValues(ctx context.Context, name string, columns []string, fn func(values string) error) error {
+	var key []string
 	var err error
 	if d.pg {
-		rows, err = d.db.Query("SELECT * FROM "+name+" LIMIT $1 OFFSET $2;", max, offset)
+		key, err = d.getPostgresTableKey(ctx, name)
 	} else {
-		rows, err = d.db.Query("SELECT * FROM "+name+" LIMIT ? OFFSET ?;", max, offset)
+		key, err = d.getMySQLTableKey(ctx, name)
 	}
-
 	if err != nil {
-		return "", err
+		return err
+	}
+
+	// PostgreSQL tables are read without the rows of the tables inheriting from them, which are dumped separately.
+	ident := d.tableIdent(name)
+	if d.pg {
+		ident = "ONLY " + ident
+	}
+	sel := "SELECT " + selectColumns(columns) + " FROM " + ident
+	q := d.queries[name]
+	if len(key) == 0 || !q.paged() {
+		rows, err := d.querier().QueryContext(ctx, sel+q.clauses())
+		if err != nil {
+			return err
+		}
+
+		_, _, err = d.readTableValues(name, rows, nil, fn)
+		return err
+	}
+
+	cols := make([]string, len(key))
+	for i, k := range key {
+		cols[i] = quoteIdent(k, d.pg)
+	}
+
+	order := strings.Join(cols, ",")
+	cond := cols[0] + " > " + d.placeholder(1)
+	if len(key) > 1 {
+		args := make([]string, len(key))
+		for i := range key {
+			args[i] = d.placeholder(i + 1)
+		}
+		cond = "(" + order + ") > (" + strings.Join(args, ",") + ")"
+	}
+
+	var last []interface{}
+	for {
+		query := sel
+		switch {
+		case last != nil && q.Where != "":
+			query += " WHERE (" + q.Where + ") AND " + cond
+		case last != nil:
+			query += " WHERE " + cond
+		case q.Where != "":
+			query += " WHERE (" + q.Where + ")"
+		}
+		query += " ORDER BY " + order + " LIMIT " + strconv.FormatInt(d.step, 10)
+
+		rows, err := d.querier().QueryContext(ctx, query, last...)
+		if err != nil {
+			return err
+		}
+
+		var n int64
+		n, last, err = d.readTableValues(name, rows, key, fn)
+		if err != nil || n < d.step {
+			return err
+		}
 	}
+}
+
+// readTableValues reads rows, calling fn with the values for each INSERT statement, or the lines of COPY data.
+// A statement gets at most the configured number of rows (d.step by default), and is kept within the byte limit.
+// It returns the number of rows read and the values of the key columns in the last row.
+func (d *Dumper) readTableValues(name string, rows *sql.Rows, key []string, fn func(values string) error) (int64, []interface{}, error) {
 	defer rows.Close()
 
 	// Get columns
-	columns, err := rows.Columns()
+	columns, err := rows.ColumnTypes()
 	if err != nil {
-		return "", err
+		return 0, nil, err
 	}
 
 	if len(columns) == 0 {
-		return "", errors.New("No columns in table " + name + ".")
+		return 0, nil, errors.New("No columns in table " + name + ".")
 	}
 
+	kinds := columnKinds(columns, d.pg)
+	keypos := make([]int, 0, len(key))
+	for _, k := range key {
+		for i, c := range columns {
+			if c.Name() == k {
+				keypos = append(keypos, i)
+			}
+		}
+	}
+
+	if len(keypos) != len(key) {
+		return 0, nil, errors.New("Key columns missing from table " + name + ".")
+	}
+
+	maxRows := d.insertRows
+	if maxRows <= 0 {
+		maxRows = d.step
+	}
+
+	// Room for the rest of the statement and the protocol overhead.
+	overhead := int64(len("INSERT INTO "+d.tableIdent(name)+" VALUES ;")) + packetReserve
+
 	// Read data
-	datatext := make([]string, 0)
+	var count, batchRows int64
+	var last []interface{}
+	var batch strings.Builder
+	data := make([]sql.NullString, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range data {
+		ptrs[i] = &data[i]
+	}
+
+	dataStrings := make([]string, len(columns))
+	copying := d.copyData()
 	for rows.Next() {
-		data := make([]*sql.NullString, len(columns))
-		ptrs := make([]interface{}, len(columns))
-		for i, _ := range data {
-			ptrs[i] = &data[i]
+		if err := rows.Scan(ptrs...); err != nil {
+			return count, nil, err
 		}
 
-		// Read data
-		if err := rows.Scan(ptrs...); err != nil {
-			return "", err
+		var row string
+		if copying {
+			for i, value := range data {
+				dataStrings[i] = copyValue(value, kinds[i])
+			}
+			row = strings.Join(dataStrings, "\t") + "\n"
+		} else {
+			for i, value := range data {
+				dataStrings[i] = d.literal(value, kinds[i])
+			}
+			row = "(" + strings.Join(dataStrings, ",") + ")"
 		}
 
-		dataStrings := make([]string, len(columns))
+		full := batchRows >= maxRows ||
+			(d.batchBytes > 0 && overhead+int64(batch.Len()+1+len(row)) > d.batchBytes)
+		if batchRows > 0 && full {
+			if err := fn(batch.String()); err != nil {
+				return count, nil, err
+			}
+
+			d.observe(Event{Kind: BatchWritten, Table: name, Rows: batchRows, Bytes: int64(batch.Len())})
+			batch.Reset()
+			batchRows = 0
+		}
 
-		for key, value := range data {
-			if value != nil && value.Valid {
-				dataStrings[key] = "'" + value.String + "'"
+		if batchRows > 0 && !copying {
+			batch.WriteByte(',')
+		}
+		batch.WriteString(row)
+		batchRows++
+		count++
+
+		last = last[:0]
+		for _, i := range keypos {
+			if kinds[i] == kindBinary {
+				last = append(last, []byte(data[i].String))
 			} else {
-				dataStrings[key] = "null"
+				last = append(last, data[i].String)
 			}
 		}
+	}
 
-		datatext = append(datatext, "("+strings.Join(dataStrings, ",")+")")
+	if err := rows.Err(); err != nil {
+		return count, nil, err
 	}
 
-	return strings.Join(datatext, ","), rows.Err()
+	if batchRows > 0 {
+		if err := fn(batch.String()); err != nil {
+			return count, nil, err
+		}
+		d.observe(Event{Kind: BatchWritten, Table: name, Rows: batchRows, Bytes: int64(batch.Len())})
+	}
+
+	return count, last, nil
+}
+
+// splitViews separates the views in list from the tables, keeping their order.
+func splitViews(list, views []string) ([]string, []string) {
+	isView := make(map[string]bool, len(views))
+	for _, v := range views {
+		isView[v] = true
+	}
+
+	var tables, selected []string
+	for _, name := range list {
+		if isView[name] {
+			selected = append(selected, name)
+		} else {
+			tables = append(tables, name)
+		}
+	}
+	return tables, selected
+}
+
+// tableIdent returns a table name as written in SQL.
+// PostgreSQL table names are already qualified and quoted by the server.
+func (d *Dumper) tableIdent(name string) string {
+	if d.pg {
+		return name
+	}
+	return quoteIdent(name, false)
+}
+
+// placeholder returns the query parameter marker for the nth argument.
+func (d *Dumper) placeholder(n int) string {
+	if d.pg {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+// chooseTableKey returns the columns of the first index in rows which has no nullable columns.
+// Rows contain the index name, column name and whether the column is NOT NULL, ordered by index and column position.
+func chooseTableKey(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+	var key []string
+	var index string
+	usable := false
+	for rows.Next() {
+		var name string
+		var column sql.NullString
+		var notnull bool
+		if err := rows.Scan(&name, &column, &notnull); err != nil {
+			return nil, err
+		}
+
+		if name != index {
+			if usable && len(key) > 0 {
+				break
+			}
+			index = name
+			key = key[:0]
+			usable = true
+		}
+
+		if !column.Valid || !notnull {
+			usable = false
+		}
+		key = append(key, column.String)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !usable {
+		return nil, nil
+	}
+	return key, nil
 }
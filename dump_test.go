@@ -1,16 +1,59 @@
 package sqldump
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
 )
 
+func noKeyRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"INDEX_NAME", "COLUMN_NAME", "NULLABLE"})
+}
+
+func maxPacketRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"@@max_allowed_packet"}).AddRow(67108864)
+}
+
+func fullTablesRows(names ...string) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"Tables_in_Testdb", "Table_type"})
+	for _, name := range names {
+		rows.AddRow(name, "BASE TABLE")
+	}
+	return rows
+}
+
+func noInheritanceRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"name", "partitioned", "parent"})
+}
+
+func tableInfoRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"unlogged", "partitioned", "partkey", "bound", "parents"}).AddRow(false, false, nil, nil, "")
+}
+
+func noTriggerRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"TRIGGER_NAME", "EVENT_OBJECT_TABLE"})
+}
+
+func collectTableValues(d *Dumper, name string) (string, error) {
+	pages := []string{}
+	err := d.createTableValues(context.Background(), name, nil, func(values string) error {
+		pages = append(pages, values)
+		return nil
+	})
+	return strings.Join(pages, ","), err
+}
+
 func TestGetTablesOk(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -19,11 +62,12 @@ func TestGetTablesOk(t *testing.T) {
 
 	defer db.Close()
 
-	rows := sqlmock.NewRows([]string{"Tables_in_Testdb"}).
-		AddRow("Test_Table_1").
-		AddRow("Test_Table_2")
+	rows := sqlmock.NewRows([]string{"Tables_in_Testdb", "Table_type"}).
+		AddRow("Test_Table_1", "BASE TABLE").
+		AddRow("Test_View", "VIEW").
+		AddRow("Test_Table_2", "BASE TABLE")
 
-	mock.ExpectQuery("^SHOW TABLES$").WillReturnRows(rows)
+	mock.ExpectQuery("^SHOW FULL TABLES$").WillReturnRows(rows)
 
 	d, err := NewDumper(db, os.TempDir(), "test_dump")
 	if err != nil {
@@ -31,7 +75,7 @@ func TestGetTablesOk(t *testing.T) {
 		t.FailNow()
 	}
 
-	result, err := d.getMySQLTables()
+	result, views, err := d.getMySQLTables(context.Background())
 	if err != nil {
 		t.Errorf("error was not expected while updating stats: %s", err)
 	}
@@ -46,6 +90,10 @@ func TestGetTablesOk(t *testing.T) {
 	if !reflect.DeepEqual(result, expectedResult) {
 		t.Fatalf("expected %#v, got %#v", result, expectedResult)
 	}
+
+	if !reflect.DeepEqual(views, []string{"Test_View"}) {
+		t.Fatalf("expected %#v, got %#v", []string{"Test_View"}, views)
+	}
 }
 
 func TestGetTablesNil(t *testing.T) {
@@ -56,12 +104,12 @@ func TestGetTablesNil(t *testing.T) {
 
 	defer db.Close()
 
-	rows := sqlmock.NewRows([]string{"Tables_in_Testdb"}).
-		AddRow("Test_Table_1").
-		AddRow(nil).
-		AddRow("Test_Table_3")
+	rows := sqlmock.NewRows([]string{"Tables_in_Testdb", "Table_type"}).
+		AddRow("Test_Table_1", "BASE TABLE").
+		AddRow(nil, "BASE TABLE").
+		AddRow("Test_Table_3", "BASE TABLE")
 
-	mock.ExpectQuery("^SHOW TABLES$").WillReturnRows(rows)
+	mock.ExpectQuery("^SHOW FULL TABLES$").WillReturnRows(rows)
 
 	d, err := NewDumper(db, os.TempDir(), "test_dump")
 	if err != nil {
@@ -69,7 +117,7 @@ func TestGetTablesNil(t *testing.T) {
 		t.FailNow()
 	}
 
-	result, err := d.getMySQLTables()
+	result, _, err := d.getMySQLTables(context.Background())
 	if err != nil {
 		t.Errorf("error was not expected while updating stats: %s", err)
 	}
@@ -105,7 +153,7 @@ func TestGetServerVersionOk(t *testing.T) {
 		t.FailNow()
 	}
 
-	result, err := d.getServerVersion()
+	result, err := d.getServerVersion(context.Background())
 	if err != nil {
 		t.Errorf("error was not expected while updating stats: %s", err)
 	}
@@ -133,7 +181,7 @@ func TestCreateTableSQLOk(t *testing.T) {
 	rows := sqlmock.NewRows([]string{"Table", "Create Table"}).
 		AddRow("Test_Table", "CREATE TABLE 'Test_Table' (`id` int(11) NOT NULL AUTO_INCREMENT,`s` char(60) DEFAULT NULL, PRIMARY KEY (`id`))ENGINE=InnoDB DEFAULT CHARSET=latin1")
 
-	mock.ExpectQuery("^SHOW CREATE TABLE Test_Table$").WillReturnRows(rows)
+	mock.ExpectQuery("^SHOW CREATE TABLE `Test_Table`$").WillReturnRows(rows)
 
 	d, err := NewDumper(db, os.TempDir(), "test_dump")
 	if err != nil {
@@ -141,7 +189,7 @@ func TestCreateTableSQLOk(t *testing.T) {
 		t.FailNow()
 	}
 
-	result, err := d.createMySQLTableSQL("Test_Table")
+	result, err := d.createMySQLTableSQL(context.Background(), "Test_Table")
 
 	if err != nil {
 		t.Errorf("error was not expected while updating stats: %s", err)
@@ -159,6 +207,270 @@ func TestCreateTableSQLOk(t *testing.T) {
 	}
 }
 
+func TestCreatePostgresTableSQL(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectQuery("c.relpersistence").WithArgs("items").WillReturnRows(tableInfoRows())
+	mock.ExpectQuery("FROM pg_catalog.pg_attribute a").WithArgs("items").WillReturnRows(
+		sqlmock.NewRows([]string{"attname", "format_type", "collation", "default", "attidentity", "attgenerated", "attnotnull"}).
+			AddRow("id", "bigint", nil, nil, "a", "", true).
+			AddRow("name", "text", `pg_catalog."C"`, "'none'::text", "", "", false).
+			AddRow("price", "numeric(10,2)", nil, "0", "", "", true).
+			AddRow("total", "numeric", nil, "(price * 2::numeric)", "", "s", false))
+	mock.ExpectQuery("FROM pg_catalog.pg_constraint").WithArgs("items").WillReturnRows(
+		sqlmock.NewRows([]string{"contype", "conname", "def"}).
+			AddRow("p", "items_pkey", "PRIMARY KEY (id)").
+			AddRow("f", "items_owner_fkey", "FOREIGN KEY (owner) REFERENCES users(id)").
+			AddRow("c", "items_price_check", "CHECK ((price >= (0)::numeric))").
+			AddRow("u", "items_name_key", "UNIQUE (name)"))
+
+	d := NewStreamDumper(db)
+	d.pg = true
+	result := &table{Name: "items", Sequences: []*sequence{
+		{Name: "public.items_id_seq", Column: "id", Identity: true, Options: "START WITH 5 INCREMENT BY 2 MINVALUE 1 MAXVALUE 1000 CACHE 1 CYCLE"},
+	}}
+	if err := d.createPostgresTableSQL(context.Background(), result); err != nil {
+		t.Fatalf("error was not expected while creating table SQL: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	expectedResult := `CREATE TABLE items (
+    id bigint GENERATED ALWAYS AS IDENTITY (START WITH 5 INCREMENT BY 2 MINVALUE 1 MAXVALUE 1000 CACHE 1 CYCLE) NOT NULL,
+    name text COLLATE pg_catalog."C" DEFAULT 'none'::text,
+    price numeric(10,2) DEFAULT 0 NOT NULL,
+    total numeric GENERATED ALWAYS AS ((price * 2::numeric)) STORED,
+    CONSTRAINT items_price_check CHECK ((price >= (0)::numeric))
+)`
+	if result.SQL != expectedResult || !result.Override {
+		t.Fatalf("expected %#v with override, got %#v (%v)", expectedResult, result.SQL, result.Override)
+	}
+
+	constraints := []string{"items_pkey PRIMARY KEY (id)", "items_name_key UNIQUE (name)"}
+	if !reflect.DeepEqual(result.Constraints, constraints) {
+		t.Fatalf("expected %#v, got %#v", constraints, result.Constraints)
+	}
+
+	foreignKeys := []string{"items_owner_fkey FOREIGN KEY (owner) REFERENCES users(id)"}
+	if !reflect.DeepEqual(result.ForeignKeys, foreignKeys) {
+		t.Fatalf("expected %#v, got %#v", foreignKeys, result.ForeignKeys)
+	}
+
+	columns := []string{"id", "name", "price"}
+	if !reflect.DeepEqual(result.Columns, columns) {
+		t.Fatalf("expected the data columns %#v, got %#v", columns, result.Columns)
+	}
+}
+
+func TestCreatePostgresTableSQLInheritance(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	for _, name := range []string{"public.child", "public.part"} {
+		info := sqlmock.NewRows([]string{"unlogged", "partitioned", "partkey", "bound", "parents"})
+		if name == "public.child" {
+			info.AddRow(false, false, nil, nil, "public.a, public.b")
+		} else {
+			info.AddRow(false, false, nil, "DEFAULT", "public.a")
+		}
+		mock.ExpectQuery("c.relpersistence").WithArgs(name).WillReturnRows(info)
+		mock.ExpectQuery("FROM pg_catalog.pg_attribute a").WithArgs(name).WillReturnRows(
+			sqlmock.NewRows([]string{"attname", "format_type", "collation", "default", "attidentity", "attgenerated", "attnotnull"}).
+				AddRow("x", "integer", nil, nil, "", "", false))
+		mock.ExpectQuery("FROM pg_catalog.pg_constraint").WithArgs(name).WillReturnRows(sqlmock.NewRows([]string{"contype", "conname", "def"}))
+	}
+
+	d := NewStreamDumper(db)
+	d.pg = true
+	d.SetCreateIfNotExists(true)
+	child := &table{Name: "public.child"}
+	if err := d.createPostgresTableSQL(context.Background(), child); err != nil {
+		t.Fatalf("error was not expected while creating table SQL: %s", err)
+	}
+
+	if expected := "CREATE TABLE public.child (\n    x integer\n)\nINHERITS (public.a, public.b)"; child.SQL != expected {
+		t.Errorf("expected %#v, got %#v", expected, child.SQL)
+	}
+
+	if !reflect.DeepEqual(child.Columns, []string{"x"}) {
+		t.Errorf("expected the columns of an inherited table to be listed, got %#v", child.Columns)
+	}
+
+	part := &table{Name: "public.part"}
+	if err := d.createPostgresTableSQL(context.Background(), part); err != nil {
+		t.Fatalf("error was not expected while creating table SQL: %s", err)
+	}
+
+	expected := "DO $$ BEGIN\nIF NOT EXISTS (SELECT 1 FROM pg_catalog.pg_inherits WHERE inhrelid = 'public.part'::regclass) THEN\n" +
+		"ALTER TABLE ONLY public.a ATTACH PARTITION public.part DEFAULT;\nEND IF;\nEND $$"
+	if part.Attach != expected {
+		t.Errorf("expected %#v, got %#v", expected, part.Attach)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestDumpPostgresGeneratedColumns(t *testing.T) {
+	for _, format := range []DataFormat{InsertData, CopyData} {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+
+		mock.ExpectQuery("^SELECT version()").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("PostgreSQL 14.5"))
+		mock.ExpectExec("set_config").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("FROM pg_catalog.pg_tables").WillReturnRows(sqlmock.NewRows([]string{"schemaname", "tablename", "qualified"}).
+			AddRow("public", "items", "public.items"))
+		mock.ExpectQuery("pg_get_viewdef").WillReturnRows(sqlmock.NewRows([]string{"oid", "nspname", "relname", "qualified", "materialized", "def"}))
+		mock.ExpectQuery("i.inhparent IS NOT NULL").WillReturnRows(noInheritanceRows())
+		mock.ExpectQuery("^SELECT n.nspname, pg_catalog.quote_ident").WillReturnRows(sqlmock.NewRows([]string{"nspname", "quoted"}).
+			AddRow("public", "public"))
+		mock.ExpectQuery("NULL, NULL, false").WillReturnRows(sequenceRows())
+		mock.ExpectQuery("d.deptype IN \\('a', 'i'\\)").WithArgs("public.items").WillReturnRows(sequenceRows())
+		mock.ExpectQuery("c.relpersistence").WithArgs("public.items").WillReturnRows(tableInfoRows())
+		mock.ExpectQuery("FROM pg_catalog.pg_attribute a").WithArgs("public.items").WillReturnRows(
+			sqlmock.NewRows([]string{"attname", "format_type", "collation", "default", "attidentity", "attgenerated", "attnotnull"}).
+				AddRow("price", "numeric", nil, nil, "", "", true).
+				AddRow("total", "numeric", nil, "(price * 2::numeric)", "", "s", false))
+		mock.ExpectQuery("FROM pg_catalog.pg_constraint").WithArgs("public.items").WillReturnRows(sqlmock.NewRows([]string{"contype", "conname", "def"}))
+		mock.ExpectQuery("FROM pg_catalog.pg_indexes").WithArgs("public.items").WillReturnRows(sqlmock.NewRows([]string{"indexdef"}))
+		mock.ExpectQuery("FROM pg_catalog.pg_index").WithArgs("public.items").WillReturnRows(noKeyRows())
+		mock.ExpectQuery("^SELECT price FROM ONLY public.items$").WillReturnRows(sqlmock.NewRows([]string{"price"}).AddRow("1.5"))
+
+		dumper := NewStreamDumper(db)
+		dumper.SetDataFormat(format)
+		var buf bytes.Buffer
+		if err := dumper.DumpTo(&buf); err != nil {
+			t.Fatalf("Error while dumping the database: %s", err.Error())
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expections: %s", err)
+		}
+
+		expected := "INSERT INTO public.items (price) VALUES ('1.5');"
+		if format == CopyData {
+			expected = "COPY public.items (price) FROM stdin;\n1.5\n\\.\n"
+		}
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected output to contain %#v, got %#v", expected, buf.String())
+		}
+		db.Close()
+	}
+}
+
+func TestDumpPostgresPartitions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	columnRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"attname", "format_type", "collation", "default", "attidentity", "attgenerated", "attnotnull"}).
+			AddRow("taken", "date", nil, nil, "", "", true)
+	}
+
+	mock.ExpectQuery("^SELECT version()").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("PostgreSQL 14.5"))
+	mock.ExpectExec("set_config").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("FROM pg_catalog.pg_tables").WillReturnRows(sqlmock.NewRows([]string{"schemaname", "tablename", "qualified"}).
+		AddRow("public", "a_2024", "public.a_2024").
+		AddRow("public", "measurements", "public.measurements"))
+	mock.ExpectQuery("pg_get_viewdef").WillReturnRows(sqlmock.NewRows([]string{"oid", "nspname", "relname", "qualified", "materialized", "def"}))
+	mock.ExpectQuery("i.inhparent IS NOT NULL").WillReturnRows(noInheritanceRows().
+		AddRow("public.a_2024", false, "public.measurements").
+		AddRow("public.measurements", true, nil))
+	mock.ExpectQuery("^SELECT n.nspname, pg_catalog.quote_ident").WillReturnRows(sqlmock.NewRows([]string{"nspname", "quoted"}).
+		AddRow("public", "public"))
+	mock.ExpectQuery("NULL, NULL, false").WillReturnRows(sequenceRows().
+		AddRow("public", "public.ticket_seq", nil, nil, false, "bigint", 1, 1, 9223372036854775807, 1, false, 1, 5, true))
+
+	mock.ExpectQuery("d.deptype IN \\('a', 'i'\\)").WithArgs("public.measurements").WillReturnRows(sequenceRows())
+	mock.ExpectQuery("c.relpersistence").WithArgs("public.measurements").WillReturnRows(
+		sqlmock.NewRows([]string{"unlogged", "partitioned", "partkey", "bound", "parents"}).AddRow(false, true, "RANGE (taken)", nil, ""))
+	mock.ExpectQuery("FROM pg_catalog.pg_attribute a").WithArgs("public.measurements").WillReturnRows(columnRows())
+	mock.ExpectQuery("FROM pg_catalog.pg_constraint").WithArgs("public.measurements").WillReturnRows(
+		sqlmock.NewRows([]string{"contype", "conname", "def"}).AddRow("p", "measurements_pkey", "PRIMARY KEY (taken)"))
+	mock.ExpectQuery("FROM pg_catalog.pg_indexes").WithArgs("public.measurements").WillReturnRows(
+		sqlmock.NewRows([]string{"indexdef"}).AddRow("CREATE INDEX measurements_taken_idx ON ONLY public.measurements USING btree (taken)"))
+
+	mock.ExpectQuery("d.deptype IN \\('a', 'i'\\)").WithArgs("public.a_2024").WillReturnRows(sequenceRows())
+	mock.ExpectQuery("c.relpersistence").WithArgs("public.a_2024").WillReturnRows(
+		sqlmock.NewRows([]string{"unlogged", "partitioned", "partkey", "bound", "parents"}).
+			AddRow(true, false, nil, "FOR VALUES FROM ('2024-01-01') TO ('2025-01-01')", "public.measurements"))
+	mock.ExpectQuery("FROM pg_catalog.pg_attribute a").WithArgs("public.a_2024").WillReturnRows(columnRows())
+	mock.ExpectQuery("FROM pg_catalog.pg_constraint").WithArgs("public.a_2024").WillReturnRows(sqlmock.NewRows([]string{"contype", "conname", "def"}))
+	mock.ExpectQuery("FROM pg_catalog.pg_indexes").WithArgs("public.a_2024").WillReturnRows(sqlmock.NewRows([]string{"indexdef"}))
+	mock.ExpectQuery("FROM pg_catalog.pg_index").WithArgs("public.a_2024").WillReturnRows(noKeyRows())
+	mock.ExpectQuery("^SELECT taken FROM ONLY public.a_2024$").WillReturnRows(sqlmock.NewRows([]string{"taken"}).AddRow("2024-05-01"))
+
+	dumper := NewStreamDumper(db)
+	var buf bytes.Buffer
+	if err := dumper.DumpTo(&buf); err != nil {
+		t.Fatalf("Error while dumping the database: %s", err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	if strings.Contains(buf.String(), "Dumping data for table public.measurements") {
+		t.Errorf("expected no data for the partitioned table, got %#v", buf.String())
+	}
+
+	statements := splitAll(t, buf.String(), true)
+	// Tables are dropped before the standalone sequences their defaults may use, partitions first.
+	expected := []string{
+		"DROP TABLE IF EXISTS public.a_2024",
+		"DROP TABLE IF EXISTS public.measurements",
+		"DROP SEQUENCE IF EXISTS public.ticket_seq",
+		"CREATE TABLE public.measurements (\n    taken date NOT NULL\n)\nPARTITION BY RANGE (taken)",
+		"CREATE UNLOGGED TABLE public.a_2024 (\n    taken date NOT NULL\n)",
+		"ALTER TABLE ONLY public.measurements ATTACH PARTITION public.a_2024 FOR VALUES FROM ('2024-01-01') TO ('2025-01-01')",
+		"INSERT INTO public.a_2024 (taken) VALUES ('2024-05-01')",
+		"ALTER TABLE public.measurements ADD CONSTRAINT measurements_pkey PRIMARY KEY (taken)",
+		"CREATE INDEX measurements_taken_idx ON public.measurements USING btree (taken)",
+	}
+
+	pos := 0
+	for _, e := range expected {
+		found := false
+		for ; pos < len(statements); pos++ {
+			if statements[pos] == e {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			t.Fatalf("expected statement %#v in order in:\n%s", e, strings.Join(statements, "\n"))
+		}
+	}
+}
+
+func TestParentsFirst(t *testing.T) {
+	list := []string{"public.c", "public.b", "public.a", "public.d"}
+	parents := map[string][]string{"public.c": {"public.b"}, "public.b": {"public.a", "public.x"}}
+	expected := []string{"public.a", "public.b", "public.c", "public.d"}
+	if result := parentsFirst(list, parents); !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+}
+
 func TestCreateTableValuesOk(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -171,7 +483,8 @@ func TestCreateTableValuesOk(t *testing.T) {
 		AddRow(1, "test@test.de", "Test Name 1").
 		AddRow(2, "test2@test.de", "Test Name 2")
 
-	mock.ExpectQuery("^SELECT (.+) FROM test$").WillReturnRows(rows)
+	mock.ExpectQuery("FROM information_schema.STATISTICS").WithArgs("test").WillReturnRows(noKeyRows())
+	mock.ExpectQuery("^SELECT (.+) FROM `test`$").WillReturnRows(rows)
 
 	d, err := NewDumper(db, os.TempDir(), "test_dump")
 	if err != nil {
@@ -179,7 +492,7 @@ func TestCreateTableValuesOk(t *testing.T) {
 		t.FailNow()
 	}
 
-	result, err := d.createTableValues("test", 0, 100)
+	result, err := collectTableValues(d, "test")
 	if err != nil {
 		t.Errorf("error was not expected while updating stats: %s", err)
 	}
@@ -209,7 +522,8 @@ func TestCreateTableValuesNil(t *testing.T) {
 		AddRow(2, "test2@test.de", "Test Name 2").
 		AddRow(3, "", "Test Name 3")
 
-	mock.ExpectQuery("^SELECT (.+) FROM test$").WillReturnRows(rows)
+	mock.ExpectQuery("FROM information_schema.STATISTICS").WithArgs("test").WillReturnRows(noKeyRows())
+	mock.ExpectQuery("^SELECT (.+) FROM `test`$").WillReturnRows(rows)
 
 	d, err := NewDumper(db, os.TempDir(), "test_dump")
 	if err != nil {
@@ -217,7 +531,7 @@ func TestCreateTableValuesNil(t *testing.T) {
 		t.FailNow()
 	}
 
-	result, err := d.createTableValues("test", 0, 100)
+	result, err := collectTableValues(d, "test")
 	if err != nil {
 		t.Errorf("error was not expected while updating stats: %s", err)
 	}
@@ -245,12 +559,7 @@ func TestCreateTableOk(t *testing.T) {
 	createTableRows := sqlmock.NewRows(
 		[]string{"Table", "Create Table"}).AddRow("Test_Table", "CREATE TABLE 'Test_Table' (`id` int(11) NOT NULL AUTO_INCREMENT,`s` char(60) DEFAULT NULL, PRIMARY KEY (`id`))ENGINE=InnoDB DEFAULT CHARSET=latin1")
 
-	createTableValueRows := sqlmock.NewRows([]string{"id", "email", "name"}).
-		AddRow(1, nil, "Test Name 1").
-		AddRow(2, "test2@test.de", "Test Name 2")
-
-	mock.ExpectQuery("^SHOW CREATE TABLE Test_Table$").WillReturnRows(createTableRows)
-	mock.ExpectQuery("^SELECT (.+) FROM Test_Table$").WillReturnRows(createTableValueRows)
+	mock.ExpectQuery("^SHOW CREATE TABLE `Test_Table`$").WillReturnRows(createTableRows)
 
 	d, err := NewDumper(db, os.TempDir(), "test_dump")
 	if err != nil {
@@ -258,7 +567,7 @@ func TestCreateTableOk(t *testing.T) {
 		t.FailNow()
 	}
 
-	result, err := d.createMySQLTable("Test_Table")
+	result, err := d.createMySQLTable(context.Background(), "Test_Table")
 	if err != nil {
 		t.Errorf("error was not expected while updating stats: %s", err)
 	}
@@ -269,9 +578,8 @@ func TestCreateTableOk(t *testing.T) {
 	}
 
 	expectedResult := &table{
-		Name:   "Test_Table",
-		SQL:    "CREATE TABLE 'Test_Table' (`id` int(11) NOT NULL AUTO_INCREMENT,`s` char(60) DEFAULT NULL, PRIMARY KEY (`id`))ENGINE=InnoDB DEFAULT CHARSET=latin1",
-		Values: "('1',null,'Test Name 1'),('2','test2@test.de','Test Name 2')",
+		Name: "`Test_Table`",
+		SQL:  "CREATE TABLE 'Test_Table' (`id` int(11) NOT NULL AUTO_INCREMENT,`s` char(60) DEFAULT NULL, PRIMARY KEY (`id`))ENGINE=InnoDB DEFAULT CHARSET=latin1",
 	}
 
 	if !reflect.DeepEqual(result, expectedResult) {
@@ -290,9 +598,6 @@ func TestDumpOk(t *testing.T) {
 	}
 
 	defer db.Close()
-	showTablesRows := sqlmock.NewRows([]string{"Tables_in_Testdb"}).
-		AddRow("Test_Table")
-
 	serverVersionRows := sqlmock.NewRows([]string{"Version()"}).
 		AddRow("test_version")
 
@@ -304,9 +609,12 @@ func TestDumpOk(t *testing.T) {
 		AddRow(2, "test2@test.de", "Test Name 2")
 
 	mock.ExpectQuery("^SELECT version()").WillReturnRows(serverVersionRows)
-	mock.ExpectQuery("^SHOW TABLES$").WillReturnRows(showTablesRows)
-	mock.ExpectQuery("^SHOW CREATE TABLE Test_Table$").WillReturnRows(createTableRows)
-	mock.ExpectQuery("^SELECT (.+) FROM Test_Table$").WillReturnRows(createTableValueRows)
+	mock.ExpectQuery("^SELECT @@max_allowed_packet$").WillReturnRows(maxPacketRows())
+	mock.ExpectQuery("^SHOW FULL TABLES$").WillReturnRows(fullTablesRows("Test_Table"))
+	mock.ExpectQuery("FROM information_schema.TRIGGERS").WillReturnRows(noTriggerRows())
+	mock.ExpectQuery("^SHOW CREATE TABLE `Test_Table`$").WillReturnRows(createTableRows)
+	mock.ExpectQuery("FROM information_schema.STATISTICS").WithArgs("Test_Table").WillReturnRows(noKeyRows())
+	mock.ExpectQuery("^SELECT (.+) FROM `Test_Table`$").WillReturnRows(createTableValueRows)
 
 	dumper, err := NewDumper(db, os.TempDir(), tmpname)
 	if err != nil {
@@ -352,24 +660,24 @@ func TestDumpOk(t *testing.T) {
 
 
 --
--- Table structure for table Test_Table
+-- Table structure for table \Test_Table\
 --
 
-DROP TABLE IF EXISTS Test_Table;
+DROP TABLE IF EXISTS \Test_Table\;
 /*!40101 SET @saved_cs_client     = @@character_set_client */;
 /*!40101 SET character_set_client = utf8 */;
 CREATE TABLE 'Test_Table' (\id\ int(11) NOT NULL AUTO_INCREMENT,\email\ char(60) DEFAULT NULL, \name\ char(60), PRIMARY KEY (\id\))ENGINE=InnoDB DEFAULT CHARSET=latin1;
 /*!40101 SET character_set_client = @saved_cs_client */;
 --
--- Dumping data for table Test_Table
+-- Dumping data for table \Test_Table\
 --
 
-LOCK TABLES Test_Table WRITE;
-/*!40000 ALTER TABLE Test_Table DISABLE KEYS */;
+LOCK TABLES \Test_Table\ WRITE;
+/*!40000 ALTER TABLE \Test_Table\ DISABLE KEYS */;
 
-INSERT INTO Test_Table VALUES ('1',null,'Test Name 1'),('2','test2@test.de','Test Name 2');
+INSERT INTO \Test_Table\ VALUES ('1',null,'Test Name 1'),('2','test2@test.de','Test Name 2');
 
-/*!40000 ALTER TABLE Test_Table ENABLE KEYS */;
+/*!40000 ALTER TABLE \Test_Table\ ENABLE KEYS */;
 UNLOCK TABLES;
 
 `
@@ -378,3 +686,382 @@ UNLOCK TABLES;
 		t.Fatalf("expected %#v, got %#v", expected, result)
 	}
 }
+
+func TestDumpToOk(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	serverVersionRows := sqlmock.NewRows([]string{"Version()"}).
+		AddRow("test_version")
+
+	createTableRows := sqlmock.NewRows([]string{"Table", "Create Table"}).
+		AddRow("Test_Table", "CREATE TABLE `Test_Table` (`id` int(11) NOT NULL)")
+
+	createTableValueRows := sqlmock.NewRows([]string{"id"}).
+		AddRow(1).
+		AddRow(2)
+
+	mock.ExpectQuery("^SELECT version()").WillReturnRows(serverVersionRows)
+	mock.ExpectQuery("^SELECT @@max_allowed_packet$").WillReturnRows(maxPacketRows())
+	mock.ExpectQuery("^SHOW FULL TABLES$").WillReturnRows(fullTablesRows("Test_Table"))
+	mock.ExpectQuery("FROM information_schema.TRIGGERS").WillReturnRows(noTriggerRows())
+	mock.ExpectQuery("^SHOW CREATE TABLE `Test_Table`$").WillReturnRows(createTableRows)
+	mock.ExpectQuery("FROM information_schema.STATISTICS").WithArgs("Test_Table").WillReturnRows(noKeyRows())
+	mock.ExpectQuery("^SELECT (.+) FROM `Test_Table`$").WillReturnRows(createTableValueRows)
+
+	dumper := NewStreamDumper(db)
+	if dumper.Path() != "" {
+		t.Fatalf("expected empty path, got %s", dumper.Path())
+	}
+
+	if err := dumper.Dump("Test_Table"); err == nil {
+		t.Fatalf("expected an error when dumping to a file without a path")
+	}
+
+	var buf bytes.Buffer
+	if err := dumper.DumpTo(&buf, "Test_Table"); err != nil {
+		t.Fatalf("Error while dumping the database: %s", err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	expected := "INSERT INTO `Test_Table` VALUES ('1'),('2');"
+	if !strings.Contains(buf.String(), expected) {
+		t.Fatalf("expected output to contain %#v, got %#v", expected, buf.String())
+	}
+}
+
+func TestDumpConsistent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectQuery("^SELECT version()").WillReturnRows(sqlmock.NewRows([]string{"Version()"}).AddRow("test_version"))
+	mock.ExpectQuery("^SELECT @@max_allowed_packet$").WillReturnRows(maxPacketRows())
+	mock.ExpectExec("^SET TRANSACTION ISOLATION LEVEL REPEATABLE READ$").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("^START TRANSACTION WITH CONSISTENT SNAPSHOT$").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("^SHOW FULL TABLES$").WillReturnRows(fullTablesRows("Test_Table"))
+	mock.ExpectQuery("FROM information_schema.TRIGGERS").WillReturnRows(noTriggerRows())
+	mock.ExpectQuery("^SHOW CREATE TABLE `Test_Table`$").WillReturnRows(sqlmock.NewRows([]string{"Table", "Create Table"}).
+		AddRow("Test_Table", "CREATE TABLE `Test_Table` (`id` int(11) NOT NULL)"))
+	mock.ExpectQuery("FROM information_schema.STATISTICS").WithArgs("Test_Table").WillReturnRows(noKeyRows())
+	mock.ExpectQuery("^SELECT (.+) FROM `Test_Table`$").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec("^COMMIT$").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	dumper := NewStreamDumper(db)
+	dumper.SetConsistent(true)
+	var buf bytes.Buffer
+	if err := dumper.DumpTo(&buf, "Test_Table"); err != nil {
+		t.Fatalf("Error while dumping the database: %s", err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	if dumper.conn != nil || db.Stats().Idle != 1 {
+		t.Errorf("expected the snapshot connection to be returned to the pool")
+	}
+}
+
+func TestEndSessionDiscardsPostgres(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectExec("set_config").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	d := NewStreamDumper(db)
+	d.pg = true
+	ctx := context.Background()
+	if err = d.beginSession(ctx, ""); err != nil {
+		t.Fatalf("error was not expected while beginning the session: %s", err)
+	}
+
+	if err = d.endSession(ctx); err != nil {
+		t.Fatalf("error was not expected while ending the session: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	if s := db.Stats(); s.OpenConnections != 0 {
+		t.Errorf("expected the connection with an empty search_path to be closed, got %d open", s.OpenConnections)
+	}
+}
+
+func TestCreateTableValuesKeyset(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	keyRows := sqlmock.NewRows([]string{"INDEX_NAME", "COLUMN_NAME", "NULLABLE"}).
+		AddRow("PRIMARY", "a", true).
+		AddRow("PRIMARY", "b", true).
+		AddRow("u_c", "c", false)
+
+	mock.ExpectQuery(MY_TABLE_KEY).WithArgs("test").WillReturnRows(keyRows)
+	mock.ExpectQuery("SELECT * FROM `test` ORDER BY `a`,`b` LIMIT 2").
+		WillReturnRows(sqlmock.NewRows([]string{"a", "b", "c"}).AddRow(1, 1, "x").AddRow(1, 2, nil))
+	mock.ExpectQuery("SELECT * FROM `test` WHERE (`a`,`b`) > (?,?) ORDER BY `a`,`b` LIMIT 2").WithArgs("1", "2").
+		WillReturnRows(sqlmock.NewRows([]string{"a", "b", "c"}).AddRow(2, 1, "y").AddRow(3, 1, "z"))
+	mock.ExpectQuery("SELECT * FROM `test` WHERE (`a`,`b`) > (?,?) ORDER BY `a`,`b` LIMIT 2").WithArgs("3", "1").
+		WillReturnRows(sqlmock.NewRows([]string{"a", "b", "c"}))
+
+	d := NewStreamDumper(db)
+	d.SetMaxRows(2)
+	pages := []string{}
+	err = d.createTableValues(context.Background(), "test", nil, func(values string) error {
+		pages = append(pages, values)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("error was not expected while creating values: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	expectedResult := []string{"('1','1','x'),('1','2',null)", "('2','1','y'),('3','1','z')"}
+	if !reflect.DeepEqual(pages, expectedResult) {
+		t.Fatalf("expected %#v, got %#v", expectedResult, pages)
+	}
+}
+
+func TestDumpMySQLAllTables(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectQuery("^SELECT version()").WillReturnRows(sqlmock.NewRows([]string{"Version()"}).AddRow("test_version"))
+	mock.ExpectQuery("^SELECT @@max_allowed_packet$").WillReturnRows(maxPacketRows())
+	mock.ExpectQuery("^SHOW FULL TABLES$").WillReturnRows(fullTablesRows("Test_Table"))
+	mock.ExpectQuery("FROM information_schema.TRIGGERS").WillReturnRows(noTriggerRows())
+	mock.ExpectQuery("^SHOW CREATE TABLE `Test_Table`$").WillReturnRows(sqlmock.NewRows([]string{"Table", "Create Table"}).
+		AddRow("Test_Table", "CREATE TABLE `Test_Table` (`id` int(11) NOT NULL)"))
+	mock.ExpectQuery("FROM information_schema.STATISTICS").WithArgs("Test_Table").WillReturnRows(noKeyRows())
+	mock.ExpectQuery("^SELECT (.+) FROM `Test_Table`$").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2).AddRow(3))
+
+	dumper := NewStreamDumper(db)
+	dumper.SetMaxRows(2)
+	var buf bytes.Buffer
+	if err := dumper.DumpTo(&buf); err != nil {
+		t.Fatalf("Error while dumping the database: %s", err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	expected := "INSERT INTO `Test_Table` VALUES ('1'),('2');\n\nINSERT INTO `Test_Table` VALUES ('3');\n"
+	if !strings.Contains(buf.String(), expected) {
+		t.Fatalf("expected output to contain %#v, got %#v", expected, buf.String())
+	}
+}
+
+func TestCreateTableValuesInsertLimits(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"s"}).
+		AddRow("aaaa").
+		AddRow("bbbb").
+		AddRow("cccccccccccccccc").
+		AddRow("d").
+		AddRow("e").
+		AddRow("f")
+
+	mock.ExpectQuery("FROM information_schema.STATISTICS").WithArgs("t").WillReturnRows(noKeyRows())
+	mock.ExpectQuery("^SELECT (.+) FROM `t`$").WillReturnRows(rows)
+
+	d := NewStreamDumper(db)
+	// The statement overhead is len("INSERT INTO t VALUES ;") plus the reserve,
+	// leaving 20 bytes for values.
+	d.SetInsertLimits(int64(len("INSERT INTO t VALUES ;"))+packetReserve+20, 2)
+	d.batchBytes = d.insertBytes
+	pages := []string{}
+	err = d.createTableValues(context.Background(), "t", nil, func(values string) error {
+		pages = append(pages, values)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("error was not expected while creating values: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	expectedResult := []string{"('aaaa'),('bbbb')", "('cccccccccccccccc')", "('d'),('e')", "('f')"}
+	if !reflect.DeepEqual(pages, expectedResult) {
+		t.Fatalf("expected %#v, got %#v", expectedResult, pages)
+	}
+}
+
+func TestDumpConcurrent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery("^SELECT version()").WillReturnRows(sqlmock.NewRows([]string{"Version()"}).AddRow("test_version"))
+	mock.ExpectQuery("^SELECT @@max_allowed_packet$").WillReturnRows(maxPacketRows())
+	names := []string{"T1", "T2", "T3", "T4"}
+	mock.ExpectQuery("^SHOW FULL TABLES$").WillReturnRows(fullTablesRows(names...))
+	mock.ExpectQuery("FROM information_schema.TRIGGERS").WillReturnRows(noTriggerRows())
+	for _, name := range names {
+		mock.ExpectQuery("^SHOW CREATE TABLE `" + name + "`$").WillReturnRows(sqlmock.NewRows([]string{"Table", "Create Table"}).
+			AddRow(name, "CREATE TABLE `"+name+"` (`id` int(11) NOT NULL)"))
+		mock.ExpectQuery("FROM information_schema.STATISTICS").WithArgs(name).WillReturnRows(noKeyRows())
+		mock.ExpectQuery("^SELECT (.+) FROM `" + name + "`$").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(name))
+	}
+
+	dumper := NewStreamDumper(db)
+	dumper.SetConcurrency(3)
+	var buf bytes.Buffer
+	if err := dumper.DumpTo(&buf, names...); err != nil {
+		t.Fatalf("Error while dumping the database: %s", err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	result := buf.String()
+	pos := 0
+	for _, name := range names {
+		i := strings.Index(result, "INSERT INTO `"+name+"` VALUES ('"+name+"');")
+		if i < pos {
+			t.Fatalf("expected table %s after position %d in %#v", name, pos, result)
+		}
+		pos = i
+	}
+}
+
+func TestDumpContextCanceled(t *testing.T) {
+	tmpname := "test_canceled"
+	tmpFile := filepath.Join(os.TempDir(), tmpname)
+	os.Remove(tmpFile)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectQuery("^SELECT version()").WillReturnRows(sqlmock.NewRows([]string{"Version()"}).AddRow("test_version"))
+	mock.ExpectQuery("^SELECT @@max_allowed_packet$").WillReturnRows(maxPacketRows())
+	mock.ExpectQuery("^SHOW FULL TABLES$").WillDelayFor(time.Second).WillReturnRows(fullTablesRows("Test_Table"))
+
+	dumper, err := NewDumper(db, os.TempDir(), tmpname)
+	if err != nil {
+		t.Fatalf("Error creating dumper: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = dumper.DumpContext(ctx)
+	if !errors.Is(err, ErrCanceled) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a canceled dump, got %v", err)
+	}
+
+	if e, _ := exists(tmpFile); e {
+		os.Remove(tmpFile)
+		t.Fatalf("expected the partial dump to be removed")
+	}
+}
+
+// funcWriter calls its function for every write.
+type funcWriter func(p []byte) (int, error)
+
+func (f funcWriter) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func TestDumpTablesLimitsTemp(t *testing.T) {
+	dir, err := os.MkdirTemp("", "sqldump")
+	if err != nil {
+		t.Fatalf("Error creating a directory: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+	d, err := NewDumper(nil, dir, "backup.sql")
+	if err != nil {
+		t.Fatalf("Error creating dumper: %s", err)
+	}
+
+	// Tables are pending from when they start until they are copied to the output.
+	var mu sync.Mutex
+	pending, most, inDir := 0, 0, true
+	out := funcWriter(func(p []byte) (int, error) {
+		mu.Lock()
+		pending--
+		mu.Unlock()
+		return len(p), nil
+	})
+
+	d.SetConcurrency(2)
+	list := []string{"a", "b", "c", "d", "e", "f"}
+	err = d.dumpTables(context.Background(), out, list, func(ctx context.Context, d *Dumper, w io.Writer, name string) error {
+		mu.Lock()
+		pending++
+		if pending > most {
+			most = pending
+		}
+		mu.Unlock()
+
+		if files, _ := os.ReadDir(dir); len(files) == 0 {
+			inDir = false
+		}
+
+		// The first table is slow, so the others would get ahead of it.
+		if name == "a" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		_, err := io.WriteString(w, name)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Error while dumping the tables: %s", err)
+	}
+
+	if most > 2 {
+		t.Errorf("expected at most 2 tables waiting to be copied, got %d", most)
+	}
+
+	if !inDir {
+		t.Errorf("expected the temporary files in the directory of the dump")
+	}
+
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("expected no temporary files left, got %s", files[0].Name())
+	}
+}
@@ -9,33 +9,84 @@ import (
 
 // Dumper represents a database.
 type Dumper struct {
-	db   *sql.DB
-	path string
-	step int64
-	pg   bool
+	db             *sql.DB
+	path           string
+	step           int64
+	pg             bool
+	compression    Compression
+	level          int
+	binary         BinaryEncoding
+	consistent     bool
+	conn           *sql.Conn
+	insertBytes    int64
+	insertRows     int64
+	batchBytes     int64
+	workers        int
+	objects        Objects
+	stripDefiner   bool
+	refresh        bool
+	schemas        []string
+	excludeSchemas []string
+	format         DataFormat
+	copyTo         CopyToFunc
+	includeTables  []tablePattern
+	excludeTables  []tablePattern
+	excludeData    []tablePattern
+	tableQueries   map[string]TableQuery
+	queries        map[string]TableQuery
+	content        Content
+	keepTables     bool
+	ifNotExists    bool
+	truncate       bool
+	observer       Observer
+	progress       *progress
+	manifest       bool
+	overwrite      OverwritePolicy
+	file           string
 }
 
+// packetReserve is kept free in every INSERT statement for protocol overhead.
+const packetReserve = 1024
+
+// NewDumper creates a dumper which writes to a file in dir.
+// The basename is passed through time.Format, so it may contain a time layout.
 func NewDumper(db *sql.DB, dir, basename string) (*Dumper, error) {
 	if !isDir(dir) {
 		return nil, errors.New("Invalid directory")
 	}
 
-	path := filepath.Join(dir, time.Now().Format(basename))
+	d := NewStreamDumper(db)
+	d.path = filepath.Join(dir, time.Now().Format(basename))
+	return d, nil
+}
+
+// NewStreamDumper creates a dumper without a file path.
+// Use DumpTo to write the dump to any io.Writer.
+func NewStreamDumper(db *sql.DB) *Dumper {
 	return &Dumper{
-		db:   db,
-		path: path,
-		step: 1000,
-	}, nil
+		db:      db,
+		step:    1000,
+		objects: DefaultObjects,
+	}
 }
 
 // SetMaxRows sets the number of rows to fetch at a time.
 // Default is 1000. Lower this if running out of memory or timing out.
 func (d *Dumper) SetMaxRows(n int64) {
-	if d.step > 0 {
+	if n > 0 {
 		d.step = n
 	}
 }
 
+// SetInsertLimits caps the size of each extended INSERT statement.
+// If maxBytes is 0, MySQL dumps use the server's max_allowed_packet and PostgreSQL dumps have no byte limit.
+// A negative maxBytes disables the byte limit. A single row larger than the limit gets a statement of its own.
+// If maxRows is 0, statements hold up to the number of rows set with SetMaxRows.
+func (d *Dumper) SetInsertLimits(maxBytes, maxRows int64) {
+	d.insertBytes = maxBytes
+	d.insertRows = maxRows
+}
+
 // Closes the dumper.
 // Will also close the database the dumper is connected to.
 //
@@ -47,7 +98,16 @@ func (d *Dumper) Close() error {
 	return d.db.Close()
 }
 
-// Path returns the full path of the generated dump.
+// Path returns the full path of the generated dump, including any compression extension.
+// After a dump with the AddSuffix overwrite policy, it is the path the dump was written to.
+// It is empty for dumpers created with NewStreamDumper.
 func (d *Dumper) Path() string {
-	return d.path
+	if d.path == "" {
+		return ""
+	}
+
+	if d.file != "" {
+		return d.file
+	}
+	return d.path + d.compression.Extension()
 }
@@ -0,0 +1,130 @@
+package sqldump
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// OverwritePolicy decides what Dump does when the dump file already exists.
+type OverwritePolicy int
+
+const (
+	// FailIfExists returns an error without dumping. This is the default.
+	FailIfExists OverwritePolicy = iota
+	// Overwrite replaces the existing file once the new dump is complete.
+	Overwrite
+	// AddSuffix writes to the first free name with a numeric suffix before the .sql and compression extensions,
+	// like backup-2.sql.gz.
+	AddSuffix
+)
+
+// SetOverwrite sets what Dump does when the dump file already exists.
+func (d *Dumper) SetOverwrite(p OverwritePolicy) {
+	d.overwrite = p
+}
+
+// targetPath returns the path to write the dump to, according to the overwrite policy.
+func (d *Dumper) targetPath() (string, error) {
+	path := d.path + d.compression.Extension()
+	e, _ := exists(path)
+	switch {
+	case !e || d.overwrite == Overwrite:
+		return path, nil
+	case d.overwrite == AddSuffix:
+		// Other dots may come from a time layout in the basename, like db-2006.01.02.
+		name, ext := d.path, d.compression.Extension()
+		if n := len(name) - len(".sql"); len(filepath.Base(name)) > len(".sql") && strings.EqualFold(name[n:], ".sql") {
+			name, ext = name[:n], name[n:]+ext
+		}
+
+		for n := 2; ; n++ {
+			path = name + "-" + strconv.Itoa(n) + ext
+			if e, _ = exists(path); !e {
+				return path, nil
+			}
+		}
+	}
+	return "", errors.New("Dump '" + path + "' already exists.")
+}
+
+// createTemp creates a temporary file next to path, so it can be moved into place.
+// Like os.Create, it is created with mode 0666 before the umask.
+func createTemp(path string) (*os.File, error) {
+	dir, base := filepath.Split(path)
+	for try := 0; ; try++ {
+		name := dir + "." + base + "." + strconv.FormatUint(uint64(rand.Uint32()), 10) + ".tmp"
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if !os.IsExist(err) || try == 100 {
+			return f, err
+		}
+	}
+}
+
+// commitTemp makes a complete dump durable, and moves it into place.
+// A file at path is only replaced with the Overwrite policy, and keeps its mode.
+func commitTemp(f *os.File, path string, p OverwritePolicy) error {
+	if p == Overwrite {
+		if fi, err := os.Stat(path); err == nil {
+			if err = f.Chmod(fi.Mode().Perm()); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// Unlike a rename, a link fails if another file was created at path since it was chosen.
+	// Filesystems without hard links, like FAT and many network mounts, fail with another error,
+	// and the file is renamed if path is still free.
+	rename := p == Overwrite
+	if !rename {
+		err := linkFile(f.Name(), path)
+		if os.IsExist(err) {
+			return errors.New("Dump '" + path + "' already exists.")
+		}
+
+		if err == nil {
+			os.Remove(f.Name())
+		} else if e, _ := exists(path); e {
+			return errors.New("Dump '" + path + "' already exists.")
+		}
+		rename = err != nil
+	}
+
+	if rename {
+		if err := os.Rename(f.Name(), path); err != nil {
+			return err
+		}
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// linkFile is os.Link, replaced in tests.
+var linkFile = os.Link
+
+// syncDir makes the entries of a directory durable, such as a renamed file.
+// Windows can't sync directories, and doesn't need to.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+	return f.Sync()
+}
@@ -0,0 +1,233 @@
+package sqldump
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestTargetPath(t *testing.T) {
+	dir, err := os.MkdirTemp("", "sqldump")
+	if err != nil {
+		t.Fatalf("Error creating a directory: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+	d, err := NewDumper(nil, dir, "backup.sql")
+	if err != nil {
+		t.Fatalf("Error creating dumper: %s", err)
+	}
+
+	d.SetCompression(Gzip, 0)
+	path := filepath.Join(dir, "backup.sql.gz")
+	if result, err := d.targetPath(); err != nil || result != path {
+		t.Fatalf("expected %#v, got %#v (%v)", path, result, err)
+	}
+
+	for _, name := range []string{"backup.sql.gz", "backup-2.sql.gz"} {
+		if err = os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("Error creating a file: %s", err)
+		}
+	}
+
+	if _, err = d.targetPath(); err == nil {
+		t.Errorf("expected an error for an existing dump")
+	}
+
+	d.SetOverwrite(Overwrite)
+	if result, err := d.targetPath(); err != nil || result != path {
+		t.Errorf("expected %#v, got %#v (%v)", path, result, err)
+	}
+
+	d.SetOverwrite(AddSuffix)
+	expected := filepath.Join(dir, "backup-3.sql.gz")
+	if result, err := d.targetPath(); err != nil || result != expected {
+		t.Errorf("expected %#v, got %#v (%v)", expected, result, err)
+	}
+
+	// The dots of a time layout are part of the name.
+	d, _ = NewDumper(nil, dir, "db-2006.01.02")
+	d.SetOverwrite(AddSuffix)
+	name := filepath.Base(d.path)
+	if err = os.WriteFile(d.path, nil, 0644); err != nil {
+		t.Fatalf("Error creating a file: %s", err)
+	}
+
+	expected = filepath.Join(dir, name+"-2")
+	if result, err := d.targetPath(); err != nil || result != expected {
+		t.Errorf("expected %#v, got %#v (%v)", expected, result, err)
+	}
+}
+
+func TestCommitTemp(t *testing.T) {
+	dir, err := os.MkdirTemp("", "sqldump")
+	if err != nil {
+		t.Fatalf("Error creating a directory: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "backup.sql")
+	f, err := createTemp(path)
+	if err != nil {
+		t.Fatalf("Error creating a temporary file: %s", err)
+	}
+
+	// A file created after the path was chosen is kept.
+	if err = os.WriteFile(path, []byte("other"), 0600); err != nil {
+		t.Fatalf("Error creating a file: %s", err)
+	}
+
+	if err = commitTemp(f, path, FailIfExists); err == nil {
+		t.Fatalf("expected an error for an existing dump")
+	}
+
+	os.Remove(f.Name())
+	if b, _ := os.ReadFile(path); string(b) != "other" {
+		t.Fatalf("expected the existing file to be kept, got %#v", string(b))
+	}
+
+	// Overwriting keeps the mode of the file replaced.
+	if f, err = createTemp(path); err != nil {
+		t.Fatalf("Error creating a temporary file: %s", err)
+	}
+
+	if err = commitTemp(f, path, Overwrite); err != nil {
+		t.Fatalf("Error moving the dump into place: %s", err)
+	}
+
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 || fi.Size() != 0 {
+		t.Fatalf("expected an empty file with mode 0600, got %v (%v)", fi, err)
+	}
+
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Fatalf("expected only the dump in the directory, got %d files", len(files))
+	}
+}
+
+func emptyDumpMock(t *testing.T) (*Dumper, sqlmock.Sqlmock, string, func()) {
+	dir, err := os.MkdirTemp("", "sqldump")
+	if err != nil {
+		t.Fatalf("Error creating a directory: %s", err)
+	}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	d, err := NewDumper(db, dir, "backup.sql")
+	if err != nil {
+		t.Fatalf("Error creating dumper: %s", err)
+	}
+
+	return d, mock, dir, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestDumpOverwrite(t *testing.T) {
+	d, mock, dir, done := emptyDumpMock(t)
+	defer done()
+
+	mock.ExpectQuery("^SELECT version()").WillReturnRows(sqlmock.NewRows([]string{"Version()"}).AddRow("test_version"))
+	mock.ExpectQuery("^SELECT @@max_allowed_packet$").WillReturnRows(maxPacketRows())
+	mock.ExpectQuery("^SHOW FULL TABLES$").WillReturnRows(fullTablesRows())
+	mock.ExpectQuery("FROM information_schema.TRIGGERS").WillReturnRows(noTriggerRows())
+
+	path := filepath.Join(dir, "backup.sql")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatalf("Error creating a file: %s", err)
+	}
+
+	d.SetOverwrite(Overwrite)
+	if err := d.Dump(); err != nil {
+		t.Fatalf("Error while dumping the database: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil || !strings.HasPrefix(string(b), "-- Go SQL Dump") {
+		t.Fatalf("expected the dump to replace the file, got %#v (%v)", string(b), err)
+	}
+
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Fatalf("expected only the dump in the directory, got %d files", len(files))
+	}
+}
+
+func TestCommitTempWithoutLinks(t *testing.T) {
+	dir, err := os.MkdirTemp("", "sqldump")
+	if err != nil {
+		t.Fatalf("Error creating a directory: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+	defer func() { linkFile = os.Link }()
+	linkFile = func(oldname, newname string) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EPERM}
+	}
+
+	path := filepath.Join(dir, "backup.sql")
+	f, err := createTemp(path)
+	if err != nil {
+		t.Fatalf("Error creating a temporary file: %s", err)
+	}
+
+	if _, err = f.WriteString("dump"); err != nil {
+		t.Fatalf("Error writing the temporary file: %s", err)
+	}
+
+	if err = commitTemp(f, path, FailIfExists); err != nil {
+		t.Fatalf("Error moving the dump into place: %s", err)
+	}
+
+	if b, _ := os.ReadFile(path); string(b) != "dump" {
+		t.Fatalf("expected the dump to be renamed into place, got %#v", string(b))
+	}
+
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Fatalf("expected only the dump in the directory, got %d files", len(files))
+	}
+
+	// An existing file is still kept.
+	if f, err = createTemp(path); err != nil {
+		t.Fatalf("Error creating a temporary file: %s", err)
+	}
+
+	defer os.Remove(f.Name())
+	if err = commitTemp(f, path, FailIfExists); err == nil {
+		t.Fatalf("expected an error for an existing dump")
+	}
+
+	if b, _ := os.ReadFile(path); string(b) != "dump" {
+		t.Fatalf("expected the existing file to be kept, got %#v", string(b))
+	}
+}
+
+func TestDumpRemovesTemp(t *testing.T) {
+	d, mock, dir, done := emptyDumpMock(t)
+	defer done()
+
+	mock.ExpectQuery("^SELECT version()").WillReturnError(errors.New("connection lost"))
+
+	if err := d.Dump(); err == nil {
+		t.Fatalf("expected an error from the dump")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("expected no files after a failed dump, got %s", files[0].Name())
+	}
+}
@@ -0,0 +1,169 @@
+package sqldump
+
+import (
+	"errors"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// tablePattern matches table and view names.
+// Patterns are globs as in path.Match, or regular expressions between slashes like /^log_\d+$/.
+// A glob containing a dot matches the name with its schema, or database for MySQL/MariaDB; others match the bare name.
+// A regular expression matches if it finds a match in either.
+type tablePattern struct {
+	glob      string
+	re        *regexp.Regexp
+	qualified bool
+}
+
+func compilePatterns(patterns []string) ([]tablePattern, error) {
+	list := make([]tablePattern, len(patterns))
+	for i, p := range patterns {
+		if len(p) > 1 && strings.HasPrefix(p, "/") && strings.HasSuffix(p, "/") {
+			re, err := regexp.Compile(p[1 : len(p)-1])
+			if err != nil {
+				return nil, err
+			}
+
+			list[i].re = re
+			continue
+		}
+
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, errors.New("Invalid table pattern " + p + ".")
+		}
+		list[i].glob = p
+		list[i].qualified = strings.Contains(p, ".")
+	}
+	return list, nil
+}
+
+func (p tablePattern) match(schema, name string) bool {
+	if p.re != nil {
+		return p.re.MatchString(name) || p.re.MatchString(schema+"."+name)
+	}
+
+	if p.qualified {
+		name = schema + "." + name
+	}
+	ok, _ := path.Match(p.glob, name)
+	return ok
+}
+
+func matchAny(patterns []tablePattern, schema, name string) bool {
+	for _, p := range patterns {
+		if p.match(schema, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetIncludeTables limits dumps to the tables and views matching any of the patterns.
+// Names given to Dump are selected first, then filtered by the patterns.
+func (d *Dumper) SetIncludeTables(patterns ...string) error {
+	list, err := compilePatterns(patterns)
+	if err != nil {
+		return err
+	}
+
+	d.includeTables = list
+	return nil
+}
+
+// SetExcludeTables leaves the tables and views matching any of the patterns out of dumps.
+func (d *Dumper) SetExcludeTables(patterns ...string) error {
+	list, err := compilePatterns(patterns)
+	if err != nil {
+		return err
+	}
+
+	d.excludeTables = list
+	return nil
+}
+
+// SetExcludeData dumps the structure of the tables matching any of the patterns without their rows,
+// for tables like logs and sessions. PostgreSQL sequence values are still restored.
+func (d *Dumper) SetExcludeData(patterns ...string) error {
+	list, err := compilePatterns(patterns)
+	if err != nil {
+		return err
+	}
+
+	d.excludeData = list
+	return nil
+}
+
+// filtersTables reports whether table patterns were set, so names need their schema or database.
+func (d *Dumper) filtersTables() bool {
+	return len(d.includeTables) > 0 || len(d.excludeTables) > 0 || len(d.excludeData) > 0
+}
+
+// includeTable reports whether a table or view is dumped.
+func (d *Dumper) includeTable(schema, name string) bool {
+	if len(d.includeTables) > 0 && !matchAny(d.includeTables, schema, name) {
+		return false
+	}
+	return !matchAny(d.excludeTables, schema, name)
+}
+
+// includeData reports whether the rows of a table are dumped.
+func (d *Dumper) includeData(schema, name string) bool {
+	return !matchAny(d.excludeData, schema, name)
+}
+
+// filterPostgres applies the table patterns to the selected tables and views,
+// and returns the selected tables whose rows are left out.
+func (d *Dumper) filterPostgres(list []string, tables []pgName, views []*object) ([]string, []*object, map[string]bool) {
+	names := make(map[string]pgName, len(tables))
+	for _, t := range tables {
+		names[t.Qualified] = t
+	}
+
+	var selected []string
+	noData := map[string]bool{}
+	for _, name := range list {
+		t := names[name]
+		if !d.includeTable(t.Schema, t.Name) {
+			continue
+		}
+
+		selected = append(selected, name)
+		if !d.includeData(t.Schema, t.Name) {
+			noData[name] = true
+		}
+	}
+
+	var objects []*object
+	for _, v := range views {
+		if d.includeTable(v.rel.Schema, v.rel.Name) {
+			objects = append(objects, v)
+		}
+	}
+	return selected, objects, noData
+}
+
+// filterMySQL applies the table patterns to the selected tables and views of database,
+// and returns the selected tables whose rows are left out.
+func (d *Dumper) filterMySQL(database string, list, views []string) ([]string, []string, map[string]bool) {
+	var selected, objects []string
+	noData := map[string]bool{}
+	for _, name := range list {
+		if !d.includeTable(database, name) {
+			continue
+		}
+
+		selected = append(selected, name)
+		if !d.includeData(database, name) {
+			noData[name] = true
+		}
+	}
+
+	for _, name := range views {
+		if d.includeTable(database, name) {
+			objects = append(objects, name)
+		}
+	}
+	return selected, objects, noData
+}
@@ -0,0 +1,122 @@
+package sqldump
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestTablePatterns(t *testing.T) {
+	d := NewStreamDumper(nil)
+	if err := d.SetIncludeTables("app.*", "users", `/^log_\d+$/`); err != nil {
+		t.Fatalf("error was not expected while setting patterns: %s", err)
+	}
+
+	if err := d.SetExcludeTables("*_tmp", "app.secret?"); err != nil {
+		t.Fatalf("error was not expected while setting patterns: %s", err)
+	}
+
+	cases := []struct {
+		schema   string
+		name     string
+		expected bool
+	}{
+		{"app", "items", true},
+		{"app", "items_tmp", false},
+		{"app", "secrets", false},
+		{"public", "items", false},
+		{"public", "users", true},
+		{"audit", "users", true},
+		{"public", "log_2024", true},
+		{"public", "log_x", false},
+	}
+
+	for _, c := range cases {
+		if result := d.includeTable(c.schema, c.name); result != c.expected {
+			t.Errorf("expected %v for %s.%s, got %v", c.expected, c.schema, c.name, result)
+		}
+	}
+
+	if err := d.SetExcludeTables("/(/"); err == nil {
+		t.Errorf("expected an error for an invalid regular expression")
+	}
+
+	if err := d.SetExcludeData("[a-"); err == nil {
+		t.Errorf("expected an error for an invalid glob")
+	}
+}
+
+func TestFilterPostgres(t *testing.T) {
+	tables := []pgName{
+		{"app", "items", "app.items"},
+		{"public", "sessions", "public.sessions"},
+		{"public", "items_tmp", "public.items_tmp"},
+	}
+	views := []*object{{Name: "public.v", rel: pgName{"public", "v", "public.v"}}}
+
+	d := NewStreamDumper(nil)
+	d.SetExcludeTables("*_tmp", "public.v")
+	d.SetExcludeData("sessions")
+	list, selected, noData := d.filterPostgres([]string{"app.items", "public.sessions", "public.items_tmp"}, tables, views)
+
+	if expected := []string{"app.items", "public.sessions"}; !reflect.DeepEqual(list, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, list)
+	}
+
+	if len(selected) != 0 {
+		t.Fatalf("expected no views, got %#v", selected)
+	}
+
+	if expected := map[string]bool{"public.sessions": true}; !reflect.DeepEqual(noData, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, noData)
+	}
+}
+
+func TestDumpMySQLExcludeData(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectQuery("^SELECT version()").WillReturnRows(sqlmock.NewRows([]string{"Version()"}).AddRow("test_version"))
+	mock.ExpectQuery("^SELECT @@max_allowed_packet$").WillReturnRows(maxPacketRows())
+	mock.ExpectQuery("^SHOW FULL TABLES$").WillReturnRows(fullTablesRows("sessions", "users", "users_tmp"))
+	mock.ExpectQuery(`^SELECT DATABASE\(\)$`).WillReturnRows(sqlmock.NewRows([]string{"DATABASE()"}).AddRow("shop"))
+	mock.ExpectQuery("FROM information_schema.TRIGGERS").WillReturnRows(noTriggerRows())
+	mock.ExpectQuery("^SHOW CREATE TABLE `sessions`$").WillReturnRows(sqlmock.NewRows([]string{"Table", "Create Table"}).
+		AddRow("sessions", "CREATE TABLE `sessions` (`id` int(11) NOT NULL)"))
+	mock.ExpectQuery("^SHOW CREATE TABLE `users`$").WillReturnRows(sqlmock.NewRows([]string{"Table", "Create Table"}).
+		AddRow("users", "CREATE TABLE `users` (`id` int(11) NOT NULL)"))
+	mock.ExpectQuery("FROM information_schema.STATISTICS").WithArgs("users").WillReturnRows(noKeyRows())
+	mock.ExpectQuery("^SELECT (.+) FROM `users`$").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	dumper := NewStreamDumper(db)
+	dumper.SetExcludeTables("shop.*_tmp")
+	dumper.SetExcludeData("sessions")
+	var buf bytes.Buffer
+	if err := dumper.DumpTo(&buf); err != nil {
+		t.Fatalf("Error while dumping the database: %s", err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	result := buf.String()
+	for _, s := range []string{"CREATE TABLE `sessions`", "INSERT INTO `users` VALUES ('1');"} {
+		if !strings.Contains(result, s) {
+			t.Errorf("expected output to contain %#v, got %#v", s, result)
+		}
+	}
+
+	for _, s := range []string{"LOCK TABLES `sessions`", "users_tmp"} {
+		if strings.Contains(result, s) {
+			t.Errorf("expected output without %#v, got %#v", s, result)
+		}
+	}
+}
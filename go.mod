@@ -1,8 +1,9 @@
 module github.com/grimdork/sqldump
 
-go 1.14
+go 1.21
 
 require (
 	github.com/DATA-DOG/go-sqlmock v1.5.0
+	github.com/klauspost/compress v1.17.11
 	github.com/lib/pq v1.10.7
 )
@@ -0,0 +1,209 @@
+package sqldump
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"text/template"
+)
+
+// BinaryEncoding selects how values of binary columns are written.
+type BinaryEncoding int
+
+const (
+	// HexBinary writes binary values as hex literals. This is the default.
+	HexBinary BinaryEncoding = iota
+	// Base64Binary writes binary values as base64 strings, decoded by the server on restore.
+	// The output is smaller than hex, and easier to handle for tools which read dumps without a SQL parser.
+	Base64Binary
+)
+
+// SetBinaryEncoding sets how BLOB, BINARY and bytea values are written.
+func (d *Dumper) SetBinaryEncoding(e BinaryEncoding) {
+	d.binary = e
+}
+
+// valueKind decides how values of a column are written as SQL literals.
+type valueKind int
+
+const (
+	kindString valueKind = iota
+	kindNumber
+	kindBool
+	kindBinary
+)
+
+// columnKinds maps the database type of each column to the kind of literal it needs.
+// Types the driver doesn't report are written as strings, which both servers convert as needed.
+func columnKinds(types []*sql.ColumnType, pg bool) []valueKind {
+	kinds := make([]valueKind, len(types))
+	for i, t := range types {
+		kinds[i] = typeKind(t.DatabaseTypeName(), pg)
+	}
+	return kinds
+}
+
+// typeKind returns the kind of a database type name.
+// Only bytea is binary on PostgreSQL, where bit strings are written as strings of 0 and 1.
+func typeKind(name string, pg bool) valueKind {
+	name = strings.TrimPrefix(strings.ToUpper(name), "UNSIGNED ")
+	switch name {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "INTEGER", "BIGINT",
+		"DECIMAL", "NUMERIC", "FLOAT", "DOUBLE", "REAL",
+		"INT2", "INT4", "INT8", "FLOAT4", "FLOAT8", "OID":
+		return kindNumber
+	case "BOOL", "BOOLEAN":
+		return kindBool
+	}
+
+	if pg {
+		if name == "BYTEA" {
+			return kindBinary
+		}
+	} else {
+		switch name {
+		case "BLOB", "TINYBLOB", "MEDIUMBLOB", "LONGBLOB", "BINARY", "VARBINARY", "BIT", "GEOMETRY":
+			return kindBinary
+		}
+	}
+	return kindString
+}
+
+// literal returns a value as a SQL literal for the dumper's dialect.
+func (d *Dumper) literal(v sql.NullString, kind valueKind) string {
+	if !v.Valid {
+		return "null"
+	}
+
+	switch kind {
+	case kindNumber:
+		// Special values like NaN and Infinity have to be quoted.
+		if isNumber(v.String) {
+			return v.String
+		}
+	case kindBool:
+		switch strings.ToLower(v.String) {
+		case "t", "true", "1":
+			return "true"
+		case "f", "false", "0":
+			return "false"
+		}
+	case kindBinary:
+		return d.binaryLiteral(v.String)
+	}
+
+	return quoteString(v.String, d.pg)
+}
+
+// binaryLiteral returns raw bytes as a literal which the server converts back to the same bytes.
+func (d *Dumper) binaryLiteral(b string) string {
+	if d.binary == Base64Binary {
+		s := base64.StdEncoding.EncodeToString([]byte(b))
+		if d.pg {
+			return "decode('" + s + "','base64')"
+		}
+		return "FROM_BASE64('" + s + "')"
+	}
+
+	if d.pg {
+		return "'\\x" + hex.EncodeToString([]byte(b)) + "'::bytea"
+	}
+
+	if b == "" {
+		return "''"
+	}
+	return "0x" + hex.EncodeToString([]byte(b))
+}
+
+// isNumber reports whether s is a plain decimal number which is safe to write unquoted.
+func isNumber(s string) bool {
+	i := 0
+	if i < len(s) && (s[i] == '-' || s[i] == '+') {
+		i++
+	}
+
+	digits := 0
+	for ; i < len(s) && s[i] >= '0' && s[i] <= '9'; i++ {
+		digits++
+	}
+
+	if i < len(s) && s[i] == '.' {
+		i++
+		for ; i < len(s) && s[i] >= '0' && s[i] <= '9'; i++ {
+			digits++
+		}
+	}
+
+	if digits == 0 {
+		return false
+	}
+
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '-' || s[i] == '+') {
+			i++
+		}
+
+		exp := i
+		for ; i < len(s) && s[i] >= '0' && s[i] <= '9'; i++ {
+		}
+
+		if i == exp {
+			return false
+		}
+	}
+
+	return i == len(s)
+}
+
+// quoteString returns s as a string literal.
+// MySQL uses backslash escapes, like mysqldump does.
+// PostgreSQL dumps set standard_conforming_strings, so only quotes are doubled.
+func quoteString(s string, pg bool) string {
+	var b strings.Builder
+	b.Grow(len(s) + 2)
+	b.WriteByte('\'')
+	if pg {
+		b.WriteString(strings.Replace(s, "'", "''", -1))
+		b.WriteByte('\'')
+		return b.String()
+	}
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case 0:
+			b.WriteString(`\0`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\x1a':
+			b.WriteString(`\Z`)
+		case '\'', '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// commentText makes s safe to write on a -- comment line. Quoted names may contain line breaks,
+// which would end the comment, so they are replaced with spaces like pg_dump does.
+func commentText(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+// templateFuncs are the functions available to the dump templates.
+var templateFuncs = template.FuncMap{"comment": commentText}
+
+// quoteIdent quotes an identifier with double quotes for PostgreSQL, or backticks for MySQL/MariaDB.
+func quoteIdent(s string, pg bool) string {
+	if pg {
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	}
+	return "`" + strings.ReplaceAll(s, "`", "``") + "`"
+}
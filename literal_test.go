@@ -0,0 +1,227 @@
+package sqldump
+
+import (
+	"database/sql"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLiteral(t *testing.T) {
+	d := NewStreamDumper(nil)
+	cases := []struct {
+		value    sql.NullString
+		kind     valueKind
+		pg       bool
+		expected string
+	}{
+		{sql.NullString{}, kindNumber, false, "null"},
+		{sql.NullString{String: "-12.5e3", Valid: true}, kindNumber, false, "-12.5e3"},
+		{sql.NullString{String: "NaN", Valid: true}, kindNumber, true, "'NaN'"},
+		{sql.NullString{String: "1; DROP TABLE x", Valid: true}, kindNumber, false, "'1; DROP TABLE x'"},
+		{sql.NullString{String: "true", Valid: true}, kindBool, true, "true"},
+		{sql.NullString{String: "f", Valid: true}, kindBool, true, "false"},
+		{sql.NullString{String: "it's \\ \"x\"\n\x00", Valid: true}, kindString, false, `'it\'s \\ \"x\"\n\0'`},
+		{sql.NullString{String: "it's \\ \"x\"\n", Valid: true}, kindString, true, "'it''s \\ \"x\"\n'"},
+		{sql.NullString{String: "\x00\xff'", Valid: true}, kindBinary, false, "0x00ff27"},
+		{sql.NullString{String: "", Valid: true}, kindBinary, false, "''"},
+		{sql.NullString{String: "\x00\xff'", Valid: true}, kindBinary, true, `'\x00ff27'::bytea`},
+	}
+
+	for _, c := range cases {
+		d.pg = c.pg
+		d.binary = HexBinary
+		if result := d.literal(c.value, c.kind); result != c.expected {
+			t.Errorf("expected %#v, got %#v", c.expected, result)
+		}
+	}
+
+	d.binary = Base64Binary
+	d.pg = false
+	if result := d.literal(sql.NullString{String: "\x00\xff'", Valid: true}, kindBinary); result != "FROM_BASE64('AP8n')" {
+		t.Errorf("expected %#v, got %#v", "FROM_BASE64('AP8n')", result)
+	}
+
+	d.pg = true
+	if result := d.literal(sql.NullString{String: "\x00\xff'", Valid: true}, kindBinary); result != "decode('AP8n','base64')" {
+		t.Errorf("expected %#v, got %#v", "decode('AP8n','base64')", result)
+	}
+}
+
+func TestTypeKind(t *testing.T) {
+	cases := []struct {
+		name     string
+		pg       bool
+		expected valueKind
+	}{
+		{"UNSIGNED BIGINT", false, kindNumber},
+		{"INT4", true, kindNumber},
+		{"BOOL", true, kindBool},
+		{"VARBINARY", false, kindBinary},
+		{"BIT", false, kindBinary},
+		{"BYTEA", true, kindBinary},
+		{"BIT", true, kindString},
+		{"VARBIT", true, kindString},
+		{"BLOB", true, kindString},
+	}
+
+	for _, c := range cases {
+		if result := typeKind(c.name, c.pg); result != c.expected {
+			t.Errorf("expected kind %d for %s (pg %v), got %d", c.expected, c.name, c.pg, result)
+		}
+	}
+}
+
+func TestCreateTableValuesTyped(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	rows := sqlmock.NewRowsWithColumnDefinition(
+		sqlmock.NewColumn("id").OfType("BIGINT", int64(0)),
+		sqlmock.NewColumn("price").OfType("DECIMAL", ""),
+		sqlmock.NewColumn("name").OfType("VARCHAR", ""),
+	).
+		AddRow(1, "9.95", "O'Brien").
+		AddRow(2, nil, "back\\slash")
+
+	mock.ExpectQuery("FROM information_schema.STATISTICS").WithArgs("test").WillReturnRows(noKeyRows())
+	mock.ExpectQuery("^SELECT (.+) FROM `test`$").WillReturnRows(rows)
+
+	d, err := NewDumper(db, os.TempDir(), "test_dump")
+	if err != nil {
+		t.Fatalf("Error creating dumper: %s", err.Error())
+	}
+
+	result, err := collectTableValues(d, "test")
+	if err != nil {
+		t.Errorf("error was not expected while creating values: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	expectedResult := `(1,9.95,'O\'Brien'),(2,null,'back\\slash')`
+	if !reflect.DeepEqual(result, expectedResult) {
+		t.Fatalf("expected %#v, got %#v", expectedResult, result)
+	}
+}
+
+// unquoteString reverses a string literal the way the server parses it.
+func unquoteString(lit string, pg bool) string {
+	lit = lit[1 : len(lit)-1]
+	if pg {
+		return strings.Replace(lit, "''", "'", -1)
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(lit); i++ {
+		c := lit[i]
+		switch {
+		case c == '\\' && i+1 < len(lit):
+			i++
+			switch lit[i] {
+			case '0':
+				b.WriteByte(0)
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'b':
+				b.WriteByte('\b')
+			case 'Z':
+				b.WriteByte('\x1a')
+			default:
+				b.WriteByte(lit[i])
+			}
+		case c == '\'' && i+1 < len(lit) && lit[i+1] == '\'':
+			i++
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// FuzzQuoteString checks that any string survives being written into a dump,
+// split into statements again and parsed by the server.
+func FuzzQuoteString(f *testing.F) {
+	for _, s := range []string{"", "plain", "it's", `back\slash'`, "semi;colon", "--", "/*", "$$", "\x00\n\r\x1a\"`"} {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		for _, pg := range []bool{false, true} {
+			lit := quoteString(s, pg)
+			stmt := "INSERT INTO t VALUES (" + lit + ")"
+			scanner := newStatementScanner(strings.NewReader(stmt+";\nSELECT 1;\n"), pg)
+
+			first, _, err := scanner.next()
+			if err != nil || first != stmt {
+				t.Fatalf("pg=%v: expected statement %#v, got %#v (%v)", pg, stmt, first, err)
+			}
+
+			second, _, err := scanner.next()
+			if err != nil || second != "SELECT 1" {
+				t.Fatalf("pg=%v: literal %#v swallowed the next statement, got %#v (%v)", pg, lit, second, err)
+			}
+
+			if _, _, err = scanner.next(); err != io.EOF {
+				t.Fatalf("pg=%v: expected end of input, got %v", pg, err)
+			}
+
+			if result := unquoteString(lit, pg); result != s {
+				t.Fatalf("pg=%v: expected %#v, got %#v", pg, s, result)
+			}
+		}
+	})
+}
+
+func TestQuoteIdent(t *testing.T) {
+	if result := quoteIdent("we`ird", false); result != "`we``ird`" {
+		t.Errorf("expected %#v, got %#v", "`we``ird`", result)
+	}
+
+	if result := quoteIdent(`We"ird`, true); result != `"We""ird"` {
+		t.Errorf("expected %#v, got %#v", `"We""ird"`, result)
+	}
+}
+
+func TestCommentedNames(t *testing.T) {
+	for _, pg := range []bool{false, true} {
+		name := quoteIdent("a\nDROP DATABASE prod;\n", pg)
+		tpl := mytableheader
+		if pg {
+			tpl = pgtableheader + pgtablesql
+		}
+
+		thead, err := template.New("tableheader").Funcs(templateFuncs).Parse(tpl)
+		if err != nil {
+			t.Fatalf("Error parsing the template: %s", err)
+		}
+
+		var b strings.Builder
+		data := dump{Structure: true, Drop: true, Table: &table{Name: name, SQL: "CREATE TABLE " + name + " (id int)"}}
+		if err = thead.Execute(&b, data); err != nil {
+			t.Fatalf("Error executing the template: %s", err)
+		}
+
+		for _, stmt := range splitAll(t, b.String(), pg) {
+			if strings.HasPrefix(stmt, "DROP DATABASE") {
+				t.Fatalf("pg=%v: expected the name to stay in its comment, got %#v", pg, b.String())
+			}
+		}
+	}
+}
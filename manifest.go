@@ -0,0 +1,116 @@
+package sqldump
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Manifest describes a dump file, so its integrity can be checked without parsing the SQL.
+type Manifest struct {
+	DumpVersion   string          `json:"dump_version"`
+	ServerVersion string          `json:"server_version"`
+	Started       time.Time       `json:"started"`
+	Finished      time.Time       `json:"finished"`
+	Tables        []ManifestTable `json:"tables"`
+	// File is the base name of the dump file, and Size and SHA256 are its size and checksum as stored.
+	File   string `json:"file"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ManifestTable describes the data of a table in a dump.
+// Bytes and SHA256 cover its data section, the INSERT statements or COPY block as written before compression.
+type ManifestTable struct {
+	Name   string `json:"name"`
+	Rows   int64  `json:"rows"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+var emptySHA256 = hex.EncodeToString(sha256.New().Sum(nil))
+
+// SetManifest writes a JSON manifest to ManifestPath() after each successful Dump.
+func (d *Dumper) SetManifest(write bool) {
+	d.manifest = write
+}
+
+// ManifestPath returns the path of the manifest written next to the dump file.
+func (d *Dumper) ManifestPath() string {
+	return d.Path() + ".manifest.json"
+}
+
+// hashWriter passes writes on to w, counting and hashing them.
+type hashWriter struct {
+	w     io.Writer
+	h     hash.Hash
+	bytes int64
+}
+
+func newHashWriter(w io.Writer) *hashWriter {
+	return &hashWriter{w: w, h: sha256.New()}
+}
+
+func (s *hashWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	s.h.Write(p[:n])
+	s.bytes += int64(n)
+	return n, err
+}
+
+func (s *hashWriter) sum() string {
+	return hex.EncodeToString(s.h.Sum(nil))
+}
+
+// dataSection returns the writer for the data section of a table, which is hashed when a manifest is written.
+func (d *Dumper) dataSection(w io.Writer) io.Writer {
+	if d.progress == nil || !d.manifest {
+		return w
+	}
+	return newHashWriter(w)
+}
+
+// endDataSection records the size and checksum of the data section of a table.
+func (d *Dumper) endDataSection(name string, w io.Writer) {
+	s, ok := w.(*hashWriter)
+	if !ok {
+		return
+	}
+
+	p := d.progress
+	p.mu.Lock()
+	p.sections[name] = ManifestTable{Bytes: s.bytes, SHA256: s.sum()}
+	p.mu.Unlock()
+}
+
+// writeManifest writes the manifest of the dump file written through f.
+func (d *Dumper) writeManifest(f *hashWriter) error {
+	p := d.progress
+	m := Manifest{
+		DumpVersion:   version,
+		ServerVersion: p.serverVersion,
+		Started:       p.start,
+		Finished:      time.Now(),
+		Tables:        make([]ManifestTable, 0, len(p.order)),
+		File:          filepath.Base(d.Path()),
+		Size:          f.bytes,
+		SHA256:        f.sum(),
+	}
+
+	for _, name := range p.order {
+		if t, ok := p.finished[name]; ok {
+			m.Tables = append(m.Tables, t)
+		}
+	}
+
+	b, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(d.ManifestPath(), append(b, '\n'), 0644)
+}
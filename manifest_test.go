@@ -0,0 +1,88 @@
+package sqldump
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDumpManifest(t *testing.T) {
+	tmpname := "test_manifest"
+	tmpFile := filepath.Join(os.TempDir(), tmpname)
+	os.Remove(tmpFile)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectQuery("^SELECT version()").WillReturnRows(sqlmock.NewRows([]string{"Version()"}).AddRow("test_version"))
+	mock.ExpectQuery("^SELECT @@max_allowed_packet$").WillReturnRows(maxPacketRows())
+	mock.ExpectQuery("^SHOW FULL TABLES$").WillReturnRows(fullTablesRows("t", "sessions"))
+	mock.ExpectQuery(`^SELECT DATABASE\(\)$`).WillReturnRows(sqlmock.NewRows([]string{"DATABASE()"}).AddRow("shop"))
+	mock.ExpectQuery("FROM information_schema.TRIGGERS").WillReturnRows(noTriggerRows())
+	mock.ExpectQuery("^SHOW CREATE TABLE `t`$").WillReturnRows(sqlmock.NewRows([]string{"Table", "Create Table"}).
+		AddRow("t", "CREATE TABLE `t` (`id` int(11) NOT NULL)"))
+	mock.ExpectQuery("FROM information_schema.STATISTICS").WithArgs("t").WillReturnRows(noKeyRows())
+	mock.ExpectQuery("^SELECT (.+) FROM `t`$").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectQuery("^SHOW CREATE TABLE `sessions`$").WillReturnRows(sqlmock.NewRows([]string{"Table", "Create Table"}).
+		AddRow("sessions", "CREATE TABLE `sessions` (`id` int(11) NOT NULL)"))
+
+	dumper, err := NewDumper(db, os.TempDir(), tmpname)
+	if err != nil {
+		t.Fatalf("Error creating dumper: %s", err)
+	}
+
+	dumper.SetManifest(true)
+	dumper.SetExcludeData("sessions")
+	if err = dumper.Dump(); err != nil {
+		t.Fatalf("Error while dumping the database: %s", err)
+	}
+
+	defer os.Remove(tmpFile)
+	defer os.Remove(dumper.ManifestPath())
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	b, err := os.ReadFile(dumper.ManifestPath())
+	if err != nil {
+		t.Fatalf("Error reading the manifest: %s", err)
+	}
+
+	var m Manifest
+	if err = json.Unmarshal(b, &m); err != nil {
+		t.Fatalf("Error decoding the manifest: %s", err)
+	}
+
+	dump, err := os.ReadFile(tmpFile)
+	if err != nil {
+		t.Fatalf("Error reading the dump: %s", err)
+	}
+
+	sum := sha256.Sum256(dump)
+	if m.File != tmpname || m.Size != int64(len(dump)) || m.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("expected the manifest to describe the dump file, got %#v", m)
+	}
+
+	if m.DumpVersion != version || m.ServerVersion != "test_version" || m.Finished.Before(m.Started) {
+		t.Errorf("unexpected versions or times in %#v", m)
+	}
+
+	section := "\nINSERT INTO `t` VALUES ('1'),('2');\n"
+	sum = sha256.Sum256([]byte(section))
+	expected := []ManifestTable{
+		{Name: "t", Rows: 2, Bytes: int64(len(section)), SHA256: hex.EncodeToString(sum[:])},
+		{Name: "sessions", SHA256: emptySHA256},
+	}
+	if len(m.Tables) != len(expected) || m.Tables[0] != expected[0] || m.Tables[1] != expected[1] {
+		t.Fatalf("expected tables %#v, got %#v", expected, m.Tables)
+	}
+}
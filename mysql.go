@@ -1,16 +1,20 @@
 package sqldump
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"io"
+	"strings"
 	"text/template"
 	"time"
 )
 
-const mytpl = `-- Go SQL Dump {{ .DumpVersion }}
+const (
+	myheader = `-- Go SQL Dump {{ .DumpVersion }}
 --
 -- ------------------------------------------------------
--- Server version	{{ .ServerVersion }}
+-- Server version	{{ comment .ServerVersion }}
 
 /*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;
 /*!40101 SET @OLD_CHARACTER_SET_RESULTS=@@CHARACTER_SET_RESULTS */;
@@ -24,95 +28,465 @@ const mytpl = `-- Go SQL Dump {{ .DumpVersion }}
 /*!40111 SET @OLD_SQL_NOTES=@@SQL_NOTES, SQL_NOTES=0 */;
 
 
-{{range .Tables}}
+`
+
+	mytableheader = `{{ with .Table }}{{ if $.Structure }}
 --
--- Table structure for table {{ .Name }}
+-- Table structure for table {{ comment .Name }}
 --
 
-DROP TABLE IF EXISTS {{ .Name }};
-/*!40101 SET @saved_cs_client     = @@character_set_client */;
+{{ if $.Drop }}DROP TABLE IF EXISTS {{ .Name }};
+{{ end }}/*!40101 SET @saved_cs_client     = @@character_set_client */;
 /*!40101 SET character_set_client = utf8 */;
 {{ .SQL }};
 /*!40101 SET character_set_client = @saved_cs_client */;
---
--- Dumping data for table {{ .Name }}
---
+{{ else }}
+{{ end }}{{ if not .NoData }}--
+-- Dumping data for table {{ comment .Name }}
+{{ if .Selection }}-- Rows: {{ comment .Selection }}
+{{ end }}--
 
-LOCK TABLES {{ .Name }} WRITE;
+{{ if $.Truncate }}TRUNCATE TABLE {{ .Name }};
+{{ end }}LOCK TABLES {{ .Name }} WRITE;
 /*!40000 ALTER TABLE {{ .Name }} DISABLE KEYS */;
-{{ if .Values }}
+{{ end }}{{ end }}`
+
+	myvaluesql = `{{ with .Table }}
 INSERT INTO {{ .Name }} VALUES {{ .Values }};
-{{ end }}
+{{ end }}`
+
+	mytablefooter = `{{ with .Table }}{{ if not .NoData }}
 /*!40000 ALTER TABLE {{ .Name }} ENABLE KEYS */;
 UNLOCK TABLES;
-{{ end }}
+{{ end }}{{ end }}`
+
+	// Views are first created as stand-ins with the right columns, so views using other views
+	// can be created in any order.
+	myviewstandin = `{{ with .Object }}
+--
+-- Temporary view structure for view {{ comment .Name }}
+--
+
+DROP TABLE IF EXISTS {{ .Name }};
+/*!50001 DROP VIEW IF EXISTS {{ .Name }}*/;
+/*!50001 CREATE VIEW {{ .Name }} AS SELECT {{ .Columns }}*/;
+{{ end }}`
+
+	myview = `{{ with .Object }}
+--
+-- Final view structure for view {{ comment .Name }}
+--
+
+/*!50001 DROP VIEW IF EXISTS {{ .Name }}*/;
+/*!50001 SET @saved_cs_client          = @@character_set_client */;
+/*!50001 SET @saved_cs_results         = @@character_set_results */;
+/*!50001 SET @saved_col_connection     = @@collation_connection */;
+/*!50001 SET character_set_client      = {{ .Charset }} */;
+/*!50001 SET character_set_results     = {{ .Charset }} */;
+/*!50001 SET collation_connection      = {{ .Collation }} */;
+{{ .SQL }};
+/*!50001 SET character_set_client      = @saved_cs_client */;
+/*!50001 SET character_set_results     = @saved_cs_results */;
+/*!50001 SET collation_connection      = @saved_col_connection */;
+{{ end }}`
+
+	// Triggers, routines and events contain semicolons, so they are written with another delimiter.
+	myroutine = `{{ with .Object }}
+--
+-- {{ .Kind }} {{ comment .Name }}
+--
+
+/*!50003 DROP {{ .Kind }} IF EXISTS {{ .Name }} */;
+/*!50003 SET @saved_cs_client      = @@character_set_client */ ;
+/*!50003 SET @saved_cs_results     = @@character_set_results */ ;
+/*!50003 SET @saved_col_connection = @@collation_connection */ ;
+/*!50003 SET character_set_client  = {{ .Charset }} */ ;
+/*!50003 SET character_set_results = {{ .Charset }} */ ;
+/*!50003 SET collation_connection  = {{ .Collation }} */ ;
+/*!50003 SET @saved_sql_mode       = @@sql_mode */ ;
+/*!50003 SET sql_mode              = {{ .SQLMode }} */ ;
+{{ if .TimeZone }}/*!50003 SET @saved_time_zone      = @@time_zone */ ;
+/*!50003 SET time_zone             = {{ .TimeZone }} */ ;
+{{ end }}DELIMITER ;;
+{{ .SQL }} ;;
+DELIMITER ;
+{{ if .TimeZone }}/*!50003 SET time_zone             = @saved_time_zone */ ;
+{{ end }}/*!50003 SET sql_mode              = @saved_sql_mode */ ;
+/*!50003 SET character_set_client  = @saved_cs_client */ ;
+/*!50003 SET character_set_results = @saved_cs_results */ ;
+/*!50003 SET collation_connection  = @saved_col_connection */ ;
+{{ end }}`
+
+	myfooter = `
 -- Dump completed on {{ .CompleteTime }}
 `
+)
 
-// DumpMySQL to file.
-func (d *Dumper) DumpMySQL(data dump, list ...string) error {
-	// Get sql for each desired table
-	for _, name := range list {
-		if t, err := d.createMySQLTable(name); err == nil {
-			data.Tables = append(data.Tables, t)
-		} else {
+// MY_TABLE_KEY lists the columns of the unique indexes of a table, primary key first.
+const MY_TABLE_KEY = `SELECT INDEX_NAME, COLUMN_NAME, NULLABLE <> 'YES'
+FROM information_schema.STATISTICS
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND NON_UNIQUE = 0
+ORDER BY INDEX_NAME = 'PRIMARY' DESC, INDEX_NAME, SEQ_IN_INDEX`
+
+// MY_SHOW_TABLES lists tables and views.
+const MY_SHOW_TABLES = "SHOW FULL TABLES"
+
+// MySQL schema object lists.
+const (
+	MY_SHOW_TRIGGERS = `SELECT TRIGGER_NAME, EVENT_OBJECT_TABLE FROM information_schema.TRIGGERS
+WHERE TRIGGER_SCHEMA = DATABASE() ORDER BY EVENT_OBJECT_TABLE, ACTION_ORDER`
+	MY_SHOW_ROUTINES = `SELECT ROUTINE_TYPE, ROUTINE_NAME FROM information_schema.ROUTINES
+WHERE ROUTINE_SCHEMA = DATABASE() ORDER BY ROUTINE_TYPE, ROUTINE_NAME`
+	MY_SHOW_EVENTS = `SELECT 'EVENT', EVENT_NAME FROM information_schema.EVENTS
+WHERE EVENT_SCHEMA = DATABASE() ORDER BY EVENT_NAME`
+	MY_VIEW_COLUMNS = `SELECT COLUMN_NAME FROM information_schema.COLUMNS
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION`
+)
+
+// myCreateColumns names the column holding the statement in SHOW CREATE results.
+var myCreateColumns = map[string]string{
+	"VIEW":      "Create View",
+	"TRIGGER":   "SQL Original Statement",
+	"PROCEDURE": "Create Procedure",
+	"FUNCTION":  "Create Function",
+	"EVENT":     "Create Event",
+}
+
+// DumpMySQL to the dump's writer.
+// Rows are written as they are read, one INSERT per page of rows.
+func (d *Dumper) DumpMySQL(ctx context.Context, data dump, list ...string) error {
+	// Prepare templates
+	head, err := template.New("header").Funcs(templateFuncs).Parse(myheader)
+	if err != nil {
+		return err
+	}
+
+	thead, err := template.New("tableheader").Funcs(templateFuncs).Parse(mytableheader)
+	if err != nil {
+		return err
+	}
+
+	tval, err := template.New("valuesql").Funcs(templateFuncs).Parse(myvaluesql)
+	if err != nil {
+		return err
+	}
+
+	tfoot, err := template.New("tablefooter").Funcs(templateFuncs).Parse(mytablefooter)
+	if err != nil {
+		return err
+	}
+
+	tstandin, err := template.New("viewstandin").Funcs(templateFuncs).Parse(myviewstandin)
+	if err != nil {
+		return err
+	}
+
+	tview, err := template.New("view").Funcs(templateFuncs).Parse(myview)
+	if err != nil {
+		return err
+	}
+
+	troutine, err := template.New("routine").Funcs(templateFuncs).Parse(myroutine)
+	if err != nil {
+		return err
+	}
+
+	foot, err := template.New("footer").Funcs(templateFuncs).Parse(myfooter)
+	if err != nil {
+		return err
+	}
+
+	if err = head.Execute(data.out, data); err != nil {
+		return err
+	}
+
+	triggers := map[string][]string{}
+	if d.objects&Triggers != 0 && data.Structure {
+		if triggers, err = d.getMySQLTriggers(ctx); err != nil {
 			return err
 		}
 	}
 
-	// Set complete time
-	data.CompleteTime = time.Now().String()
+	err = d.dumpTables(ctx, data.out, list, func(ctx context.Context, d *Dumper, w io.Writer, name string) error {
+		data := data
+		data.out = w
+		var err error
+		if data.Structure {
+			data.Table, err = d.createMySQLTable(ctx, name)
+			if err != nil {
+				return err
+			}
+		} else {
+			data.Table = &table{Name: quoteIdent(name, false)}
+		}
+
+		data.Table.NoData = data.noData[name] || !data.Data
+		data.Table.Selection = d.queries[name].String()
+		if err = thead.Execute(data.out, data); err != nil {
+			return err
+		}
+
+		if !data.Table.NoData {
+			section := d.dataSection(data.out)
+			err = d.createTableValues(ctx, name, nil, func(values string) error {
+				data.Table.Values = values
+				return tval.Execute(section, data)
+			})
+			if err != nil {
+				return err
+			}
+			d.endDataSection(name, section)
+		}
+
+		if err = tfoot.Execute(data.out, data); err != nil {
+			return err
+		}
 
-	// Write dump to file
-	t, err := template.New("mysqldump").Parse(mytpl)
+		for _, trigger := range triggers[name] {
+			if data.Object, err = d.createMySQLObject(ctx, "TRIGGER", trigger); err != nil {
+				return err
+			}
+
+			if err = troutine.Execute(data.out, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	return t.Execute(data.file, data)
+	var routines [][2]string
+	if d.objects&Events != 0 && data.Structure {
+		if routines, err = d.getMySQLRoutines(ctx, MY_SHOW_EVENTS); err != nil {
+			return err
+		}
+	}
+
+	if d.objects&Routines != 0 && data.Structure {
+		list, err := d.getMySQLRoutines(ctx, MY_SHOW_ROUTINES)
+		if err != nil {
+			return err
+		}
+		routines = append(routines, list...)
+	}
+
+	for _, r := range routines {
+		if data.Object, err = d.createMySQLObject(ctx, r[0], r[1]); err != nil {
+			return err
+		}
+
+		if err = troutine.Execute(data.out, data); err != nil {
+			return err
+		}
+	}
+
+	if d.objects&Views != 0 && data.Structure {
+		for _, name := range data.views {
+			if data.Object, err = d.createMySQLViewStandIn(ctx, name); err != nil {
+				return err
+			}
+
+			if err = tstandin.Execute(data.out, data); err != nil {
+				return err
+			}
+		}
+
+		for _, name := range data.views {
+			if data.Object, err = d.createMySQLObject(ctx, "VIEW", name); err != nil {
+				return err
+			}
+
+			if err = tview.Execute(data.out, data); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Set complete time
+	data.CompleteTime = time.Now().String()
+	return foot.Execute(data.out, data)
 }
 
-func (d *Dumper) getMySQLTables() ([]string, error) {
+// getMySQLTables returns the names of the base tables and views in the database.
+func (d *Dumper) getMySQLTables(ctx context.Context) ([]string, []string, error) {
 	tables := make([]string, 0)
+	views := make([]string, 0)
 
 	// Get table list
-	rows, err := d.db.Query("SHOW TABLES")
+	rows, err := d.querier().QueryContext(ctx, MY_SHOW_TABLES)
 	if err != nil {
-		return tables, err
+		return tables, views, err
 	}
 	defer rows.Close()
 
 	// Read result
 	for rows.Next() {
-		var table sql.NullString
-		if err := rows.Scan(&table); err != nil {
-			return tables, err
+		var table, kind sql.NullString
+		if err := rows.Scan(&table, &kind); err != nil {
+			return tables, views, err
+		}
+
+		if kind.String == "VIEW" {
+			views = append(views, table.String)
+		} else {
+			tables = append(tables, table.String)
 		}
-		tables = append(tables, table.String)
 	}
-	return tables, rows.Err()
+	return tables, views, rows.Err()
 }
 
-func (d *Dumper) createMySQLTable(name string) (*table, error) {
-	var err error
-	t := &table{Name: name}
+// getMySQLTriggers returns the names of the triggers of each table, in the order they fire.
+func (d *Dumper) getMySQLTriggers(ctx context.Context) (map[string][]string, error) {
+	rows, err := d.querier().QueryContext(ctx, MY_SHOW_TRIGGERS)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	triggers := map[string][]string{}
+	for rows.Next() {
+		var name, table sql.NullString
+		if err := rows.Scan(&name, &table); err != nil {
+			return nil, err
+		}
+		triggers[table.String] = append(triggers[table.String], name.String)
+	}
+	return triggers, rows.Err()
+}
+
+// getMySQLRoutines returns the type and name of routines or events.
+func (d *Dumper) getMySQLRoutines(ctx context.Context, query string) ([][2]string, error) {
+	rows, err := d.querier().QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := [][2]string{}
+	for rows.Next() {
+		var kind, name sql.NullString
+		if err := rows.Scan(&kind, &name); err != nil {
+			return nil, err
+		}
+		list = append(list, [2]string{kind.String, name.String})
+	}
+	return list, rows.Err()
+}
 
-	if t.SQL, err = d.createMySQLTableSQL(name); err != nil {
+// createMySQLObject gets the creation SQL and session settings of a view, trigger, routine or event.
+func (d *Dumper) createMySQLObject(ctx context.Context, kind, name string) (*object, error) {
+	rows, err := d.querier().QueryContext(ctx, "SHOW CREATE "+kind+" "+quoteIdent(name, false))
+	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	if t.Values, err = d.createTableValues(name, 0, 0); err != nil {
+	columns, err := rows.Columns()
+	if err != nil {
 		return nil, err
 	}
 
+	if !rows.Next() {
+		if err = rows.Err(); err == nil {
+			err = errors.New("No definition returned for " + kind + " " + name)
+		}
+		return nil, err
+	}
+
+	values := make([]sql.NullString, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+
+	if err = rows.Scan(ptrs...); err != nil {
+		return nil, err
+	}
+
+	result := map[string]sql.NullString{}
+	for i, c := range columns {
+		result[c] = values[i]
+	}
+
+	stmt := result[myCreateColumns[kind]]
+	if !stmt.Valid {
+		return nil, errors.New("Insufficient privileges to dump " + kind + " " + name)
+	}
+
+	o := &object{
+		Kind:      kind,
+		Name:      quoteIdent(name, false),
+		SQL:       stmt.String,
+		SQLMode:   quoteString(result["sql_mode"].String, false),
+		Charset:   result["character_set_client"].String,
+		Collation: result["collation_connection"].String,
+	}
+
+	if tz, ok := result["time_zone"]; ok {
+		o.TimeZone = quoteString(tz.String, false)
+	}
+
+	if d.stripDefiner {
+		o.SQL = stripDefiner(o.SQL)
+	}
+	return o, rows.Err()
+}
+
+// createMySQLViewStandIn creates a stand-in for a view, which has the columns of the view.
+func (d *Dumper) createMySQLViewStandIn(ctx context.Context, name string) (*object, error) {
+	rows, err := d.querier().QueryContext(ctx, MY_VIEW_COLUMNS, name)
+	if err != nil {
+		return nil, err
+	}
+
+	columns, err := getStringRows(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, c := range columns {
+		columns[i] = "1 AS " + quoteIdent(c, false)
+	}
+	return &object{Kind: "VIEW", Name: quoteIdent(name, false), Columns: strings.Join(columns, ", ")}, nil
+}
+
+// getMySQLMaxPacket returns the server's max_allowed_packet.
+func (d *Dumper) getMySQLMaxPacket(ctx context.Context) (int64, error) {
+	var n sql.NullInt64
+	err := d.querier().QueryRowContext(ctx, "SELECT @@max_allowed_packet").Scan(&n)
+	return n.Int64, err
+}
+
+func (d *Dumper) createMySQLTable(ctx context.Context, name string) (*table, error) {
+	var err error
+	t := &table{Name: quoteIdent(name, false)}
+
+	if t.SQL, err = d.createMySQLTableSQL(ctx, name); err != nil {
+		return nil, err
+	}
+
+	t.SQL = d.createIfNotExists(t.SQL, "TABLE")
+
 	return t, nil
 }
 
-func (d *Dumper) createMySQLTableSQL(name string) (string, error) {
+// getMySQLTableKey returns the columns to page through a table by, or nothing if it has no usable key.
+func (d *Dumper) getMySQLTableKey(ctx context.Context, name string) ([]string, error) {
+	rows, err := d.querier().QueryContext(ctx, MY_TABLE_KEY, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return chooseTableKey(rows)
+}
+
+func (d *Dumper) createMySQLTableSQL(ctx context.Context, name string) (string, error) {
 	// Get table creation SQL
 	var table_return sql.NullString
 	var table_sql sql.NullString
-	err := d.db.QueryRow("SHOW CREATE TABLE "+name).Scan(&table_return, &table_sql)
+	err := d.querier().QueryRowContext(ctx, "SHOW CREATE TABLE "+quoteIdent(name, false)).Scan(&table_return, &table_sql)
 	if err != nil {
 		return "", err
 	}
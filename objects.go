@@ -0,0 +1,75 @@
+package sqldump
+
+import (
+	"regexp"
+)
+
+// Objects selects which schema objects are dumped besides tables.
+type Objects int
+
+const (
+	// Views are dumped after all tables. This includes PostgreSQL materialized views.
+	Views Objects = 1 << iota
+	// Triggers are dumped after the data of their table, so they don't fire during restore.
+	Triggers
+	// Routines are stored procedures and functions. On PostgreSQL they are dumped before the tables,
+	// unless they use tables for their types or SQL-standard bodies.
+	Routines
+	// Events are MySQL/MariaDB scheduled events.
+	Events
+
+	// DefaultObjects are dumped unless SetObjects is used.
+	DefaultObjects = Views | Triggers
+	// AllObjects selects every supported object type.
+	AllObjects = Views | Triggers | Routines | Events
+)
+
+// SetObjects sets which schema objects are dumped besides tables.
+func (d *Dumper) SetObjects(o Objects) {
+	d.objects = o
+}
+
+// SetRefreshMaterializedViews fills PostgreSQL materialized views at the end of the dump.
+// Otherwise they are restored empty, and must be refreshed before use.
+func (d *Dumper) SetRefreshMaterializedViews(refresh bool) {
+	d.refresh = refresh
+}
+
+// SetStripDefiner removes DEFINER clauses from views, triggers, routines and events,
+// so they can be restored by users who don't exist on the target server.
+// The objects then get the restoring user as definer.
+func (d *Dumper) SetStripDefiner(strip bool) {
+	d.stripDefiner = strip
+}
+
+// object is a view, trigger, routine or event.
+type object struct {
+	// Kind is the object type in SQL, like VIEW or PROCEDURE.
+	Kind string
+	Name string
+	SQL  string
+	// SQLMode is the quoted sql_mode the object was created with.
+	SQLMode string
+	// TimeZone is the quoted time zone of an event.
+	TimeZone  string
+	Charset   string
+	Collation string
+	// Columns of a view's temporary stand-in.
+	Columns string
+	// rel is the schema and name of a PostgreSQL view.
+	rel pgName
+	// oid identifies a PostgreSQL view or function, and deps are the oids of the objects it uses.
+	oid  int64
+	deps []int64
+}
+
+var definerRE = regexp.MustCompile("DEFINER\\s*=\\s*(`(?:[^`]|``)*`|'(?:[^']|'')*'|[^\\s@]+)@(`(?:[^`]|``)*`|'(?:[^']|'')*'|[^\\s]+)\\s*")
+
+// stripDefiner removes the first DEFINER clause from a CREATE statement.
+func stripDefiner(s string) string {
+	loc := definerRE.FindStringIndex(s)
+	if loc == nil {
+		return s
+	}
+	return s[:loc[0]] + s[loc[1]:]
+}
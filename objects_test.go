@@ -0,0 +1,189 @@
+package sqldump
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestStripDefiner(t *testing.T) {
+	cases := map[string]string{
+		"CREATE ALGORITHM=UNDEFINED DEFINER=`root`@`localhost` SQL SECURITY DEFINER VIEW `v` AS select 1": "CREATE ALGORITHM=UNDEFINED SQL SECURITY DEFINER VIEW `v` AS select 1",
+		"CREATE DEFINER=`a``b`@`%` PROCEDURE `p`() BEGIN END":                                             "CREATE PROCEDURE `p`() BEGIN END",
+		"CREATE DEFINER='app'@'10.0.0.1' TRIGGER t BEFORE INSERT ON a FOR EACH ROW SET @x = 1":            "CREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW SET @x = 1",
+		"CREATE DEFINER=CURRENT_USER FUNCTION f() RETURNS int RETURN 1":                                   "CREATE DEFINER=CURRENT_USER FUNCTION f() RETURNS int RETURN 1",
+	}
+
+	for input, expected := range cases {
+		if result := stripDefiner(input); result != expected {
+			t.Errorf("expected %#v, got %#v", expected, result)
+		}
+	}
+}
+
+func TestDumpMySQLObjects(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectQuery("^SELECT version()").WillReturnRows(sqlmock.NewRows([]string{"Version()"}).AddRow("8.0.30"))
+	mock.ExpectQuery("^SELECT @@max_allowed_packet$").WillReturnRows(maxPacketRows())
+	mock.ExpectQuery("^SHOW FULL TABLES$").WillReturnRows(sqlmock.NewRows([]string{"Tables_in_Testdb", "Table_type"}).
+		AddRow("a", "BASE TABLE").
+		AddRow("v", "VIEW"))
+	mock.ExpectQuery("FROM information_schema.TRIGGERS").WillReturnRows(sqlmock.NewRows([]string{"TRIGGER_NAME", "EVENT_OBJECT_TABLE"}).
+		AddRow("t1", "a"))
+	mock.ExpectQuery("^SHOW CREATE TABLE `a`$").WillReturnRows(sqlmock.NewRows([]string{"Table", "Create Table"}).
+		AddRow("a", "CREATE TABLE `a` (`x` int NOT NULL)"))
+	mock.ExpectQuery("FROM information_schema.STATISTICS").WithArgs("a").WillReturnRows(noKeyRows())
+	mock.ExpectQuery("^SELECT (.+) FROM `a`$").WillReturnRows(sqlmock.NewRows([]string{"x"}).AddRow(1))
+	mock.ExpectQuery("^SHOW CREATE TRIGGER `t1`$").WillReturnRows(sqlmock.NewRows(
+		[]string{"Trigger", "sql_mode", "SQL Original Statement", "character_set_client", "collation_connection", "Database Collation", "Created"}).
+		AddRow("t1", "STRICT_TRANS_TABLES", "CREATE DEFINER=`root`@`localhost` TRIGGER t1 BEFORE INSERT ON a FOR EACH ROW BEGIN SET NEW.x = 1; END", "utf8mb4", "utf8mb4_0900_ai_ci", "utf8mb4_0900_ai_ci", nil))
+	mock.ExpectQuery("FROM information_schema.ROUTINES").WillReturnRows(sqlmock.NewRows([]string{"ROUTINE_TYPE", "ROUTINE_NAME"}).
+		AddRow("PROCEDURE", "p"))
+	mock.ExpectQuery("^SHOW CREATE PROCEDURE `p`$").WillReturnRows(sqlmock.NewRows(
+		[]string{"Procedure", "sql_mode", "Create Procedure", "character_set_client", "collation_connection", "Database Collation"}).
+		AddRow("p", "", "CREATE DEFINER=`root`@`localhost` PROCEDURE p() BEGIN SELECT 1; SELECT 2; END", "utf8mb4", "utf8mb4_0900_ai_ci", "utf8mb4_0900_ai_ci"))
+	mock.ExpectQuery("FROM information_schema.COLUMNS").WithArgs("v").WillReturnRows(sqlmock.NewRows([]string{"COLUMN_NAME"}).
+		AddRow("x").
+		AddRow("y"))
+	mock.ExpectQuery("^SHOW CREATE VIEW `v`$").WillReturnRows(sqlmock.NewRows(
+		[]string{"View", "Create View", "character_set_client", "collation_connection"}).
+		AddRow("v", "CREATE ALGORITHM=UNDEFINED DEFINER=`root`@`localhost` SQL SECURITY DEFINER VIEW `v` AS select `a`.`x` AS `x`,1 AS `y` from `a`", "utf8mb4", "utf8mb4_0900_ai_ci"))
+
+	dumper := NewStreamDumper(db)
+	dumper.SetObjects(Views | Triggers | Routines)
+	dumper.SetStripDefiner(true)
+	var buf bytes.Buffer
+	if err := dumper.DumpTo(&buf); err != nil {
+		t.Fatalf("Error while dumping the database: %s", err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	statements := splitAll(t, buf.String(), false)
+	expected := []string{
+		"/*!50003 SET sql_mode              = 'STRICT_TRANS_TABLES' */",
+		"CREATE TRIGGER t1 BEFORE INSERT ON a FOR EACH ROW BEGIN SET NEW.x = 1; END",
+		"CREATE PROCEDURE p() BEGIN SELECT 1; SELECT 2; END",
+		"/*!50001 CREATE VIEW `v` AS SELECT 1 AS `x`, 1 AS `y`*/",
+		"CREATE ALGORITHM=UNDEFINED SQL SECURITY DEFINER VIEW `v` AS select `a`.`x` AS `x`,1 AS `y` from `a`",
+	}
+
+	pos := 0
+	for _, e := range expected {
+		found := false
+		for ; pos < len(statements); pos++ {
+			if statements[pos] == e {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			t.Fatalf("expected statement %#v in order in:\n%s", e, strings.Join(statements, "\n"))
+		}
+	}
+}
+
+func TestDumpPostgresObjects(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectQuery("^SELECT version()").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("PostgreSQL 14.5"))
+	mock.ExpectExec("set_config").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("FROM pg_catalog.pg_tables").WillReturnRows(sqlmock.NewRows([]string{"schemaname", "tablename", "qualified"}).
+		AddRow("public", "a", "public.a"))
+	mock.ExpectQuery("pg_get_viewdef").WillReturnRows(sqlmock.NewRows([]string{"oid", "nspname", "relname", "qualified", "materialized", "def"}).
+		AddRow(10, "public", "m", "public.m", true, " SELECT v.x\n   FROM public.v;").
+		AddRow(11, "public", "v", "public.v", false, " SELECT a.x\n   FROM public.a;").
+		AddRow(12, "public", "w", "public.w", false, " SELECT 1 AS y;"))
+	mock.ExpectQuery("FROM pg_catalog.pg_depend d").WillReturnRows(sqlmock.NewRows([]string{"ev_class", "refobjid"}).
+		AddRow(10, 11).
+		AddRow(11, 1))
+	mock.ExpectQuery("i.inhparent IS NOT NULL").WillReturnRows(noInheritanceRows())
+	mock.ExpectQuery("^SELECT n.nspname, pg_catalog.quote_ident").WillReturnRows(sqlmock.NewRows([]string{"nspname", "quoted"}).
+		AddRow("public", "public"))
+	mock.ExpectQuery("pg_get_functiondef").WillReturnRows(sqlmock.NewRows([]string{"oid", "nspname", "kind", "name", "def"}).
+		AddRow(20, "public", "FUNCTION", "public.f()", "CREATE OR REPLACE FUNCTION public.f()\n RETURNS integer\n LANGUAGE plpgsql\nAS $function$\nBEGIN\n\tRETURN 1;\nEND;\n$function$\n").
+		AddRow(21, "public", "FUNCTION", "public.g()", "CREATE OR REPLACE FUNCTION public.g()\n RETURNS SETOF public.a\n LANGUAGE sql\nAS $function$SELECT * FROM public.a$function$\n").
+		AddRow(22, "public", "FUNCTION", "public.h()", "CREATE OR REPLACE FUNCTION public.h()\n RETURNS bigint\n LANGUAGE sql\nRETURN (SELECT count(*) FROM public.g())\n"))
+	mock.ExpectQuery("d.classid = 'pg_catalog.pg_proc'").WillReturnRows(sqlmock.NewRows([]string{"objid", "refobjid", "relation"}).
+		AddRow(22, 21, false).
+		AddRow(21, 1, true))
+	mock.ExpectQuery("NULL, NULL, false").WillReturnRows(sequenceRows())
+	mock.ExpectQuery("d.deptype IN \\('a', 'i'\\)").WithArgs("public.a").WillReturnRows(sequenceRows())
+	mock.ExpectQuery("c.relpersistence").WithArgs("public.a").WillReturnRows(tableInfoRows())
+	mock.ExpectQuery("FROM pg_catalog.pg_attribute a").WithArgs("public.a").WillReturnRows(
+		sqlmock.NewRows([]string{"attname", "format_type", "collation", "default", "attidentity", "attgenerated", "attnotnull"}).
+			AddRow("x", "integer", nil, nil, "", "", true))
+	mock.ExpectQuery("FROM pg_catalog.pg_constraint").WithArgs("public.a").WillReturnRows(sqlmock.NewRows([]string{"contype", "conname", "def"}).
+		AddRow("f", "a_x_fkey", "FOREIGN KEY (x) REFERENCES public.b(x)").
+		AddRow("p", "a_pkey", "PRIMARY KEY (x)"))
+	mock.ExpectQuery("FROM pg_catalog.pg_indexes").WithArgs("public.a").WillReturnRows(sqlmock.NewRows([]string{"indexdef"}).
+		AddRow("CREATE INDEX a_x_idx ON public.a USING btree (x)"))
+	mock.ExpectQuery("FROM pg_catalog.pg_index").WithArgs("public.a").WillReturnRows(noKeyRows())
+	mock.ExpectQuery("^SELECT (.+) FROM ONLY public.a$").WillReturnRows(sqlmock.NewRows([]string{"x"}).AddRow(1))
+
+	dumper := NewStreamDumper(db)
+	dumper.SetObjects(Views | Routines)
+	dumper.SetRefreshMaterializedViews(true)
+	var buf bytes.Buffer
+	if err := dumper.DumpTo(&buf); err != nil {
+		t.Fatalf("Error while dumping the database: %s", err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	statements := splitAll(t, buf.String(), true)
+	expected := []string{
+		"CREATE SCHEMA IF NOT EXISTS public",
+		"CREATE OR REPLACE FUNCTION public.f()\n RETURNS integer\n LANGUAGE plpgsql\nAS $function$\nBEGIN\n\tRETURN 1;\nEND;\n$function$",
+		"DROP VIEW IF EXISTS public.w CASCADE",
+		"DROP MATERIALIZED VIEW IF EXISTS public.m CASCADE",
+		"DROP VIEW IF EXISTS public.v CASCADE",
+		"DROP FUNCTION IF EXISTS public.h() CASCADE",
+		"DROP FUNCTION IF EXISTS public.g() CASCADE",
+		"DROP TABLE IF EXISTS public.a",
+		"CREATE TABLE public.a (\n    x integer NOT NULL\n)",
+		"INSERT INTO public.a VALUES ('1')",
+		"ALTER TABLE ONLY public.a ADD CONSTRAINT a_pkey PRIMARY KEY (x)",
+		"CREATE INDEX a_x_idx ON public.a USING btree (x)",
+		"ALTER TABLE ONLY public.a ADD CONSTRAINT a_x_fkey FOREIGN KEY (x) REFERENCES public.b(x)",
+		"CREATE OR REPLACE FUNCTION public.g()\n RETURNS SETOF public.a\n LANGUAGE sql\nAS $function$SELECT * FROM public.a$function$",
+		"CREATE OR REPLACE FUNCTION public.h()\n RETURNS bigint\n LANGUAGE sql\nRETURN (SELECT count(*) FROM public.g())",
+		"CREATE VIEW public.v AS\nSELECT a.x\n   FROM public.a",
+		"CREATE MATERIALIZED VIEW public.m AS\nSELECT v.x\n   FROM public.v\nWITH NO DATA",
+		"CREATE VIEW public.w AS\nSELECT 1 AS y",
+		"REFRESH MATERIALIZED VIEW public.m",
+	}
+
+	pos := 0
+	for _, e := range expected {
+		found := false
+		for ; pos < len(statements); pos++ {
+			if statements[pos] == e {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			t.Fatalf("expected statement %#v in order in:\n%s", e, strings.Join(statements, "\n"))
+		}
+	}
+}
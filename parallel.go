@@ -0,0 +1,183 @@
+package sqldump
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// SetConcurrency sets how many tables are dumped at the same time, each on its own connection.
+// Tables are written to temporary files and copied to the output in the usual order. The default is 1.
+// The files are kept in the directory of the dump, or the system's temporary directory for DumpTo,
+// and at most n of them wait to be copied.
+//
+// Consistent PostgreSQL dumps share one snapshot between all connections.
+// On MySQL/MariaDB every connection takes its own snapshot, so each table is consistent,
+// but tables are only consistent with each other if the concurrency is 1.
+func (d *Dumper) SetConcurrency(n int) {
+	if n > 0 {
+		d.workers = n
+	}
+}
+
+// tableResult is a table dumped by a worker.
+type tableResult struct {
+	file *os.File
+	err  error
+}
+
+// dumpTables calls fn for every table in list, and writes the output to out in list order.
+// fn is called on the dumper to query with, which is a worker when tables are dumped in parallel.
+func (d *Dumper) dumpTables(ctx context.Context, out io.Writer, list []string, fn func(ctx context.Context, d *Dumper, w io.Writer, name string) error) error {
+	if d.progress != nil {
+		fn = observeTable(fn)
+	}
+
+	if d.workers <= 1 || len(list) < 2 {
+		for _, name := range list {
+			if err := fn(ctx, d, out, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var snapshot string
+	if d.consistent && d.pg {
+		err := d.querier().QueryRowContext(ctx, "SELECT pg_export_snapshot()").Scan(&snapshot)
+		if err != nil {
+			return err
+		}
+	}
+
+	results := make([]chan tableResult, len(list))
+	for i := range results {
+		results[i] = make(chan tableResult, 1)
+	}
+
+	n := d.workers
+	if n > len(list) {
+		n = len(list)
+	}
+
+	// A table is only handed out when fewer than n tables are dumped or wait to be copied,
+	// so workers don't fill the disk when an early table is slow.
+	slots := make(chan struct{}, n)
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range list {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.tableWorker(ctx, snapshot, list, jobs, results, fn)
+		}()
+	}
+
+	// Every table handed to a worker gets a result, and tables are handed out in order,
+	// so waiting for them in order can't block forever.
+	var err error
+	done := 0
+	for ; done < len(list); done++ {
+		r := <-results[done]
+		if r.err != nil {
+			err = r.err
+			break
+		}
+
+		_, err = io.Copy(out, r.file)
+		removeTemp(r.file)
+		<-slots
+		if err != nil {
+			break
+		}
+	}
+
+	if err != nil {
+		cancel()
+		wg.Wait()
+		for _, c := range results[done:] {
+			select {
+			case r := <-c:
+				if r.file != nil {
+					removeTemp(r.file)
+				}
+			default:
+			}
+		}
+	}
+	return err
+}
+
+// tableWorker dumps the tables handed to it over jobs on its own connection.
+func (d *Dumper) tableWorker(ctx context.Context, snapshot string, list []string, jobs <-chan int, results []chan tableResult, fn func(ctx context.Context, d *Dumper, w io.Writer, name string) error) {
+	w := *d
+	w.conn = nil
+	var err error
+	if d.usesSession() {
+		err = w.beginSession(ctx, snapshot)
+		defer w.endSession(context.WithoutCancel(ctx))
+	}
+
+	for i := range jobs {
+		if err != nil {
+			results[i] <- tableResult{err: err}
+			continue
+		}
+
+		results[i] <- w.dumpTableToTemp(ctx, list[i], fn)
+	}
+}
+
+// dumpTableToTemp dumps a table to a temporary file, rewound for reading.
+func (d *Dumper) dumpTableToTemp(ctx context.Context, name string, fn func(ctx context.Context, d *Dumper, w io.Writer, name string) error) tableResult {
+	dir := ""
+	if d.path != "" {
+		dir = filepath.Dir(d.path)
+	}
+
+	f, err := os.CreateTemp(dir, ".sqldump-*.tmp")
+	if err != nil {
+		return tableResult{err: err}
+	}
+
+	buf := bufio.NewWriter(f)
+	if err = fn(ctx, d, buf, name); err == nil {
+		if err = buf.Flush(); err == nil {
+			_, err = f.Seek(0, io.SeekStart)
+		}
+	}
+
+	if err != nil {
+		removeTemp(f)
+		return tableResult{err: err}
+	}
+	return tableResult{file: f}
+}
+
+func removeTemp(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
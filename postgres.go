@@ -1,154 +1,172 @@
 package sqldump
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
+	"errors"
+	"io"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 )
 
 const (
-	// Show the names of all tables in database, one per row.
-	PG_SHOW_TABLES = `select tablename from pg_catalog.pg_tables where schemaname!='pg_catalog' and schemaname!='information_schema';`
-
-	// This function dumps the SQL for a specified table.
-	PG_SHOW_TABLE_SQL = `CREATE OR REPLACE FUNCTION public.show_create_table(p_table_name character varying)
-	RETURNS SETOF text AS
-  $BODY$
-  DECLARE
-	  v_table_ddl   text;
-	  column_record record;
-	  table_rec record;
-	  constraint_rec record;
-	  firstrec boolean;
-  BEGIN
-	  FOR table_rec IN
-		  SELECT c.relname FROM pg_catalog.pg_class c
-			  LEFT JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
-				  WHERE relkind = 'r'
-				  AND relname~ ('^('||p_table_name||')$')
-				  AND n.nspname <> 'pg_catalog'
-				  AND n.nspname <> 'information_schema'
-				  AND n.nspname !~ '^pg_toast'
-				  AND pg_catalog.pg_table_is_visible(c.oid)
-			ORDER BY c.relname
-	  LOOP
-  
-		  FOR column_record IN 
-			  SELECT 
-				  b.nspname as schema_name,
-				  b.relname as table_name,
-				  a.attname as column_name,
-				  pg_catalog.format_type(a.atttypid, a.atttypmod) as column_type,
-				  CASE WHEN 
-					  (SELECT substring(pg_catalog.pg_get_expr(d.adbin, d.adrelid) for 128)
-					   FROM pg_catalog.pg_attrdef d
-					   WHERE d.adrelid = a.attrelid AND d.adnum = a.attnum AND a.atthasdef) IS NOT NULL THEN
-					  'DEFAULT '|| (SELECT substring(pg_catalog.pg_get_expr(d.adbin, d.adrelid) for 128)
-									FROM pg_catalog.pg_attrdef d
-									WHERE d.adrelid = a.attrelid AND d.adnum = a.attnum AND a.atthasdef)
-				  ELSE
-					  ''
-				  END as column_default_value,
-				  CASE WHEN a.attnotnull = true THEN 
-					  'NOT NULL'
-				  ELSE
-					  'NULL'
-				  END as column_not_null,
-				  a.attnum as attnum,
-				  e.max_attnum as max_attnum
-			  FROM 
-				  pg_catalog.pg_attribute a
-				  INNER JOIN 
-				   (SELECT c.oid,
-					  n.nspname,
-					  c.relname
-					FROM pg_catalog.pg_class c
-						 LEFT JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
-					WHERE c.relname = table_rec.relname
-					  AND pg_catalog.pg_table_is_visible(c.oid)
-					ORDER BY 2, 3) b
-				  ON a.attrelid = b.oid
-				  INNER JOIN 
-				   (SELECT 
-						a.attrelid,
-						max(a.attnum) as max_attnum
-					FROM pg_catalog.pg_attribute a
-					WHERE a.attnum > 0 
-					  AND NOT a.attisdropped
-					GROUP BY a.attrelid) e
-				  ON a.attrelid=e.attrelid
-			  WHERE a.attnum > 0 
-				AND NOT a.attisdropped
-			  ORDER BY a.attnum
-		  LOOP
-			  IF column_record.attnum = 1 THEN
-				  v_table_ddl:='CREATE TABLE '||column_record.schema_name||'.'||column_record.table_name||' (';
-			  ELSE
-				  v_table_ddl:=v_table_ddl||',';
-			  END IF;
-  
-			  IF column_record.attnum <= column_record.max_attnum THEN
-				  v_table_ddl:=v_table_ddl||chr(10)||
-						   '    "'||column_record.column_name||'" '||column_record.column_type||' '||column_record.column_default_value||' '||column_record.column_not_null;
-			  END IF;
-		  END LOOP;
-  
-		  firstrec := TRUE;
-		  FOR constraint_rec IN
-			  SELECT conname, pg_get_constraintdef(c.oid) as constrainddef 
-				  FROM pg_constraint c 
-					  WHERE conrelid=(
-						  SELECT attrelid FROM pg_attribute
-						  WHERE attrelid = (
-							  SELECT oid FROM pg_class WHERE relname = table_rec.relname
-						  ) AND attname='tableoid'
-					  )
-		  LOOP
-			  v_table_ddl:=v_table_ddl||','||chr(10);
-			  v_table_ddl:=v_table_ddl||'CONSTRAINT '||constraint_rec.conname;
-			  v_table_ddl:=v_table_ddl||chr(10)||'    '||constraint_rec.constrainddef;
-			  firstrec := FALSE;
-		  END LOOP;
-		  v_table_ddl:=v_table_ddl||');';
-		  RETURN NEXT v_table_ddl;
-	  END LOOP;
-  END;
-  $BODY$
-	LANGUAGE plpgsql VOLATILE
-	COST 100;`
-
-	// This removes the table dumper from the database.
-	PG_DROP_SHOW_TABLE_SQL = `DROP FUNCTION show_create_table(p_table_name varchar);`
+	// Show the schema, name and qualified name of all tables in database, one per row.
+	// System schemas are left out like in PG_SHOW_SCHEMAS, including the temporary schemas of other sessions.
+	PG_SHOW_TABLES = `SELECT schemaname, tablename, pg_catalog.quote_ident(schemaname) || '.' || pg_catalog.quote_ident(tablename)
+FROM pg_catalog.pg_tables
+WHERE schemaname <> 'information_schema' AND schemaname !~ '^pg_'
+ORDER BY 1, 2`
+
+	// List the columns of a table with their type, collation, default and identity.
+	PG_TABLE_COLUMNS = `SELECT pg_catalog.quote_ident(a.attname), pg_catalog.format_type(a.atttypid, a.atttypmod),
+CASE WHEN a.attcollation <> t.typcollation THEN pg_catalog.quote_ident(cn.nspname) || '.' || pg_catalog.quote_ident(co.collname) END,
+pg_catalog.pg_get_expr(d.adbin, d.adrelid), a.attidentity, a.attgenerated, a.attnotnull
+FROM pg_catalog.pg_attribute a
+JOIN pg_catalog.pg_type t ON t.oid = a.atttypid
+LEFT JOIN pg_catalog.pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+LEFT JOIN pg_catalog.pg_collation co ON co.oid = a.attcollation
+LEFT JOIN pg_catalog.pg_namespace cn ON cn.oid = co.collnamespace
+WHERE a.attrelid = $1::regclass AND a.attnum > 0 AND NOT a.attisdropped
+ORDER BY a.attnum`
+
+	// List the constraints of a table, primary key first. NOT NULL is part of the columns.
+	// Constraints of partitions which are cloned from their partitioned table are left out.
+	PG_TABLE_CONSTRAINTS = `SELECT contype, pg_catalog.quote_ident(conname), pg_catalog.pg_get_constraintdef(oid)
+FROM pg_catalog.pg_constraint
+WHERE conrelid = $1::regclass AND contype IN ('c', 'p', 'u', 'x', 'f') AND conparentid = 0
+ORDER BY contype = 'p' DESC, conname`
+
+	// List the indexes of a table which don't belong to a constraint, or to an index of its partitioned table.
+	PG_TABLE_INDEXES = `SELECT i.indexdef
+FROM pg_catalog.pg_indexes i
+JOIN pg_catalog.pg_namespace n ON n.nspname = i.schemaname
+JOIN pg_catalog.pg_class c ON c.relname = i.indexname AND c.relnamespace = n.oid
+WHERE (pg_catalog.quote_ident(i.schemaname) || '.' || pg_catalog.quote_ident(i.tablename))::regclass = $1::regclass
+AND NOT EXISTS (SELECT 1 FROM pg_catalog.pg_constraint k WHERE k.conindid = c.oid AND k.contype IN ('p', 'u', 'x'))
+AND NOT EXISTS (SELECT 1 FROM pg_catalog.pg_inherits h WHERE h.inhrelid = c.oid)
+ORDER BY i.indexname`
+
+	// Show whether a table is unlogged or partitioned, its partition key and bound, and the tables it inherits from.
+	PG_TABLE_INFO = `SELECT c.relpersistence = 'u', c.relkind = 'p', pg_catalog.pg_get_partkeydef(c.oid),
+pg_catalog.pg_get_expr(c.relpartbound, c.oid),
+pg_catalog.array_to_string(ARRAY(SELECT pg_catalog.quote_ident(pn.nspname) || '.' || pg_catalog.quote_ident(p.relname)
+	FROM pg_catalog.pg_inherits i
+	JOIN pg_catalog.pg_class p ON p.oid = i.inhparent
+	JOIN pg_catalog.pg_namespace pn ON pn.oid = p.relnamespace
+	WHERE i.inhrelid = c.oid ORDER BY i.inhseqno), ', ')
+FROM pg_catalog.pg_class c
+WHERE c.oid = $1::regclass`
+
+	// List the partitioned tables, and the tables which inherit from others with their parents.
+	PG_SHOW_INHERITANCE = `SELECT pg_catalog.quote_ident(n.nspname) || '.' || pg_catalog.quote_ident(c.relname), c.relkind = 'p',
+pg_catalog.quote_ident(pn.nspname) || '.' || pg_catalog.quote_ident(p.relname)
+FROM pg_catalog.pg_class c
+JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+LEFT JOIN pg_catalog.pg_inherits i ON i.inhrelid = c.oid
+LEFT JOIN pg_catalog.pg_class p ON p.oid = i.inhparent
+LEFT JOIN pg_catalog.pg_namespace pn ON pn.oid = p.relnamespace
+WHERE c.relkind IN ('r', 'p') AND (c.relkind = 'p' OR i.inhparent IS NOT NULL)`
 
 	pgheader = `-- Go SQL Dump {{ .DumpVersion }}
 --
 -- ------------------------------------------------------
--- Server version	{{ .ServerVersion }}
+-- Server version	{{ comment .ServerVersion }}
+
+SET standard_conforming_strings = on;
+SELECT pg_catalog.set_config('search_path', '', false);
+SET check_function_bodies = false;
 
 `
 
-	pgtableheader = `{{ with .Table }}
+	pgtableheader = `{{ with .Table }}{{ if $.Structure }}
 --
--- Table structure for table {{ .Name }}
+-- Table structure for table {{ comment .Name }}
 --
-DROP TABLE IF EXISTS {{ .Name }};
-{{ .Sequences }}
-{{ end }}
+{{ range .Sequences }}{{ if not .Identity }}{{ if $.Drop }}DROP SEQUENCE IF EXISTS {{ .Name }};
+{{ end }}{{ .SQL }};
+{{ end }}{{ end }}
+{{ end }}{{ end }}
 `
 
-	pgtablesql = `{{ with .Table }}
+	pgtablesql = `{{ with .Table }}{{ if $.Structure }}
 {{ .SQL }};
+{{ if .Attach }}{{ .Attach }};
+{{ end }}{{ range .Sequences }}{{ if not .Identity }}ALTER SEQUENCE {{ .Name }} OWNED BY {{ $.Table.Name }}.{{ .Column }};
+{{ end }}{{ end }}
+{{ end }}{{ if not .NoData }}
+--
+-- Dumping data for table {{ comment .Name }}
+{{ if .Selection }}-- Rows: {{ comment .Selection }}
+{{ end }}--
+{{ end }}{{ end }}
+`
+
+	pgvaluesql = `{{with .Table}} {{if .Values}} INSERT INTO {{.Name}}{{.ColumnList}}{{if .Override}} OVERRIDING SYSTEM VALUE{{end}} VALUES {{.Values}};
+{{end}}{{end}}`
 
+	pgcopy = `{{ with .Table }}COPY {{ .Name }}{{ .ColumnList }} FROM stdin;
+{{ end }}`
+
+	// Sequences are set after the data, so new rows don't collide with restored ones.
+	pgsetval = `{{ with .Table }}{{ range .Sequences }}{{ .SetVal }};
+{{ end }}{{ end }}`
+
+	// Functions are created before the tables which may use them, as their bodies aren't checked until called.
+	// Functions which need tables for their types or SQL-standard bodies are created with the views instead.
+	pgfunction = `{{ with .Object }}
 --
--- Dumping data for table {{ .Name }}
+-- {{ .Kind }} {{ comment .Name }}
 --
+{{ .SQL }};
 {{ end }}
 `
 
-	pgvaluesql = `{{with .Table}} {{if .Values}} INSERT INTO {{.Name}} VALUES {{.Values}};
-{{end}}{{end}}`
+	// Materialized views are created empty, and filled by REFRESH MATERIALIZED VIEW when enabled.
+	// With $.Drop they have been dropped before the tables.
+	pgview = `{{ with .Object }}
+--
+-- {{ .Kind }} {{ comment .Name }}
+--
+{{ if not $.Drop }}DROP {{ .Kind }} IF EXISTS {{ .Name }} CASCADE;
+{{ end }}CREATE {{ .Kind }} {{ .Name }} AS
+{{ .SQL }}{{ if eq .Kind "MATERIALIZED VIEW" }}
+WITH NO DATA{{ end }};
+{{ end }}
+`
+
+	// Objects using tables are dropped in reverse dependency order before the tables.
+	pgdrop = `{{ with .Object }}DROP {{ .Kind }} IF EXISTS {{ .Name }} CASCADE;
+{{ end }}`
+
+	pgrefresh = `{{ with .Object }}REFRESH MATERIALIZED VIEW {{ .Name }};
+{{ end }}`
+
+	// Keys and indexes are added after the data, which loads faster without them.
+	// On partitioned tables they are added without ONLY, which adds them to the partitions as well.
+	// Constraints have no IF NOT EXISTS, so with SetCreateIfNotExists existing ones are skipped in a DO block.
+	pgindexes = `{{ with .Table }}{{ if or .Constraints .Indexes }}
+--
+-- Indexes for table {{ comment .Name }}
+--
+{{ range .Constraints }}{{ if $.IfNotExists }}` + pgskipexisting + `{{ else }}ALTER TABLE {{ if not $.Table.Partitioned }}ONLY {{ end }}{{ $.Table.Name }} ADD CONSTRAINT {{ . }};
+{{ end }}{{ end }}{{ range .Indexes }}{{ . }};
+{{ end }}{{ end }}{{ end }}`
+
+	// Foreign keys are added last, when every table they refer to exists.
+	pgforeignkeys = `{{ with .Table }}{{ if .ForeignKeys }}
+--
+-- Foreign keys for table {{ comment .Name }}
+--
+{{ range .ForeignKeys }}{{ if $.IfNotExists }}` + pgskipexisting + `{{ else }}ALTER TABLE {{ if not $.Table.Partitioned }}ONLY {{ end }}{{ $.Table.Name }} ADD CONSTRAINT {{ . }};
+{{ end }}{{ end }}{{ end }}{{ end }}`
+
+	pgskipexisting = `DO $$ BEGIN
+ALTER TABLE {{ if not $.Table.Partitioned }}ONLY {{ end }}{{ $.Table.Name }} ADD CONSTRAINT {{ . }};
+EXCEPTION WHEN duplicate_object OR duplicate_table OR invalid_table_definition THEN NULL;
+END $$;
+`
 
 	pgfooter = `-- Dump completed on {{ .CompleteTime }}
 `
@@ -175,90 +193,324 @@ DROP TABLE IF EXISTS {{ .Name }};
 	-- Dump completed on {{ .CompleteTime }}
 `
 
-	PG_GET_SEQ_LIST = `SELECT c.relname FROM pg_class c WHERE c.relkind = 'S';`
-	PG_GET_SEQ      = `select
-	sequence_schema,
-	data_type,
-	start_value,
-	minimum_value,
-	maximum_value,
-	increment
-from information_schema.sequences where sequence_name=$1
-`
+	// List the columns of the unique indexes of a table, primary key first.
+	// Generated columns aren't read with the data, so indexes on them are reported as unusable like nullable ones.
+	PG_TABLE_KEY = `SELECT i.indexrelid::regclass::text, a.attname, a.attnotnull AND a.attgenerated = ''
+FROM pg_catalog.pg_index i
+JOIN pg_catalog.pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+WHERE i.indrelid = $1::regclass AND i.indisunique AND i.indpred IS NULL AND i.indexprs IS NULL
+ORDER BY i.indisprimary DESC, i.indexrelid, array_position(i.indkey::int2[], a.attnum)`
+
+	// List views and materialized views, except those of extensions and system schemas.
+	PG_SHOW_VIEWS = `SELECT c.oid, n.nspname, c.relname,
+pg_catalog.quote_ident(n.nspname) || '.' || pg_catalog.quote_ident(c.relname), c.relkind = 'm', pg_catalog.pg_get_viewdef(c.oid)
+FROM pg_catalog.pg_class c
+JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind IN ('v', 'm')
+AND n.nspname <> 'information_schema' AND n.nspname !~ '^pg_'
+AND NOT EXISTS (SELECT 1 FROM pg_catalog.pg_depend e WHERE e.objid = c.oid AND e.deptype = 'e')
+ORDER BY 2, 3`
+
+	// List the relations each view uses.
+	PG_VIEW_DEPS = `SELECT DISTINCT r.ev_class, d.refobjid
+FROM pg_catalog.pg_depend d
+JOIN pg_catalog.pg_rewrite r ON r.oid = d.objid
+WHERE d.classid = 'pg_catalog.pg_rewrite'::regclass AND d.refclassid = 'pg_catalog.pg_class'::regclass
+AND d.refobjid <> r.ev_class`
+
+	// List functions and procedures, except those of extensions.
+	PG_SHOW_FUNCTIONS = `SELECT p.oid, n.nspname, CASE p.prokind WHEN 'p' THEN 'PROCEDURE' ELSE 'FUNCTION' END,
+p.oid::regprocedure::text, pg_catalog.pg_get_functiondef(p.oid)
+FROM pg_catalog.pg_proc p
+JOIN pg_catalog.pg_namespace n ON n.oid = p.pronamespace
+WHERE p.prokind IN ('f', 'p')
+AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+AND NOT EXISTS (SELECT 1 FROM pg_catalog.pg_depend e WHERE e.objid = p.oid AND e.deptype = 'e')
+ORDER BY 2, p.proname, 4`
+
+	// List the functions and relations each function uses, by its argument and result types or its SQL-standard body,
+	// and whether the dependency is a relation.
+	PG_FUNCTION_DEPS = `SELECT DISTINCT d.objid, COALESCE(NULLIF(t.typrelid, 0), d.refobjid), COALESCE(t.typrelid, 0) <> 0 OR d.refclassid = 'pg_catalog.pg_class'::regclass
+FROM pg_catalog.pg_depend d
+LEFT JOIN pg_catalog.pg_type t ON d.refclassid = 'pg_catalog.pg_type'::regclass AND t.oid = d.refobjid
+WHERE d.classid = 'pg_catalog.pg_proc'::regclass AND d.deptype = 'n' AND d.refobjid <> d.objid
+AND (d.refclassid IN ('pg_catalog.pg_proc'::regclass, 'pg_catalog.pg_class'::regclass) OR COALESCE(t.typrelid, 0) <> 0)`
 )
 
-// DumpPostgres to file.
-func (d *Dumper) DumpPostgres(data dump, list ...string) error {
-	var err error
-
-	// Install the procedure to generate SQL for tables.
-	err = d.installProcedure()
+// DumpPostgres to the dump's writer.
+func (d *Dumper) DumpPostgres(ctx context.Context, data dump, list ...string) error {
+	// Prepare templates
+	head, err := template.New("header").Funcs(templateFuncs).Parse(pgheader)
 	if err != nil {
 		return err
 	}
 
-	// Prepare templates
-	thead, err := template.New("tableheader").Parse(pgtableheader)
+	thead, err := template.New("tableheader").Funcs(templateFuncs).Parse(pgtableheader)
 	if err != nil {
 		return err
 	}
 
-	ttab, err := template.New("tablesql").Parse(pgtablesql)
+	ttab, err := template.New("tablesql").Funcs(templateFuncs).Parse(pgtablesql)
 	if err != nil {
 		return err
 	}
 
-	tval, err := template.New("valuesql").Parse(pgvaluesql)
+	tval, err := template.New("valuesql").Funcs(templateFuncs).Parse(pgvaluesql)
 	if err != nil {
 		println(pgvaluesql)
 		return err
 	}
 
-	tfoot, err := template.New("ftableooter").Parse(pgfooter)
+	tschema, err := template.New("schema").Funcs(templateFuncs).Parse(pgschema)
 	if err != nil {
 		return err
 	}
 
-	thead.Execute(data.file, data)
-	for _, name := range list {
-		data.Table, err = d.createPostgresTable(name)
+	tcopy, err := template.New("copy").Funcs(templateFuncs).Parse(pgcopy)
+	if err != nil {
+		return err
+	}
+
+	tsetval, err := template.New("setval").Funcs(templateFuncs).Parse(pgsetval)
+	if err != nil {
+		return err
+	}
+
+	tseq, err := template.New("sequence").Funcs(templateFuncs).Parse(pgsequence)
+	if err != nil {
+		return err
+	}
+
+	tindex, err := template.New("indexes").Funcs(templateFuncs).Parse(pgindexes)
+	if err != nil {
+		return err
+	}
+
+	tfkey, err := template.New("foreignkeys").Funcs(templateFuncs).Parse(pgforeignkeys)
+	if err != nil {
+		return err
+	}
+
+	tfunc, err := template.New("function").Funcs(templateFuncs).Parse(pgfunction)
+	if err != nil {
+		return err
+	}
+
+	tview, err := template.New("view").Funcs(templateFuncs).Parse(pgview)
+	if err != nil {
+		return err
+	}
+
+	tdrop, err := template.New("drop").Funcs(templateFuncs).Parse(pgdrop)
+	if err != nil {
+		return err
+	}
+
+	trefresh, err := template.New("refresh").Funcs(templateFuncs).Parse(pgrefresh)
+	if err != nil {
+		return err
+	}
+
+	tfoot, err := template.New("ftableooter").Funcs(templateFuncs).Parse(pgfooter)
+	if err != nil {
+		return err
+	}
+
+	if err = head.Execute(data.out, data); err != nil {
+		return err
+	}
+
+	if data.Structure {
+		schemas, err := d.getPostgresSchemas(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range schemas {
+			data.Object = &object{Kind: "SCHEMA", Name: name}
+			if err = tschema.Execute(data.out, data); err != nil {
+				return err
+			}
+		}
+	}
+
+	var late []*object
+	if d.objects&Routines != 0 && data.Structure {
+		var functions []*object
+		functions, late, err = d.getPostgresFunctions(ctx)
 		if err != nil {
 			return err
 		}
 
-		ttab.Execute(data.file, data)
+		for _, data.Object = range functions {
+			if err = tfunc.Execute(data.out, data); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Existing tables are dropped before any sequence, as their defaults may use standalone sequences,
+	// and after the views and functions which use them. Inheriting tables go before their parents.
+	objects := sortObjects(append(late, data.objects...))
+	if data.Structure && data.Drop && len(objects)+len(list) > 0 {
+		if _, err = io.WriteString(data.out, "\n--\n-- Drop existing tables and the objects which use them\n--\n"); err != nil {
+			return err
+		}
+
+		for i := len(objects) - 1; i >= 0; i-- {
+			data.Object = objects[i]
+			if err = tdrop.Execute(data.out, data); err != nil {
+				return err
+			}
+		}
+
+		for i := len(list) - 1; i >= 0; i-- {
+			if _, err = io.WriteString(data.out, "DROP TABLE IF EXISTS "+list[i]+";\n"); err != nil {
+				return err
+			}
+		}
+	}
+
+	sequences, err := d.getPostgresSequences(ctx, PG_SHOW_SEQUENCES, "")
+	if err != nil {
+		return err
+	}
+
+	for _, data.Sequence = range sequences {
+		if err = tseq.Execute(data.out, data); err != nil {
+			return err
+		}
+	}
+
+	// Tables referenced by foreign keys must be truncated along with the tables referring to them.
+	if data.Truncate {
+		var names []string
+		for _, name := range list {
+			if !data.noData[name] {
+				names = append(names, name)
+			}
+		}
 
-		max, err := d.countPostgresRows(name)
+		if len(names) > 0 {
+			if _, err = io.WriteString(data.out, pgTruncate(names, data.Structure)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Tables are kept for the indexes and foreign keys after all data.
+	var mu sync.Mutex
+	tables := make(map[string]*table, len(list))
+	err = d.dumpTables(ctx, data.out, list, func(ctx context.Context, d *Dumper, w io.Writer, name string) error {
+		data := data
+		data.out = w
+		var err error
+		data.Table, err = d.createPostgresTable(ctx, name)
 		if err != nil {
 			return err
 		}
 
-		offset := int64(0)
-		step := d.step
-		if step > max {
-			step = max
+		data.Table.NoData = data.noData[name] || !data.Data
+		data.Table.Selection = d.queries[name].String()
+		mu.Lock()
+		tables[name] = data.Table
+		mu.Unlock()
+
+		if err = thead.Execute(data.out, data); err != nil {
+			return err
 		}
-		for offset < max {
-			if data.Table.Values, err = d.createTableValues(name, offset, step); err != nil {
+
+		if err = ttab.Execute(data.out, data); err != nil {
+			return err
+		}
+
+		// Tables without data still get their sequence values.
+		section := d.dataSection(data.out)
+		switch {
+		case data.Table.NoData:
+		case d.copyData():
+			if err = tcopy.Execute(section, data); err != nil {
+				return err
+			}
+
+			if err = d.copyTable(ctx, section, name, data.Table.Columns); err != nil {
 				return err
 			}
 
-			tval.Execute(data.file, data)
-			offset += step
+			if _, err = io.WriteString(section, "\\.\n"); err != nil {
+				return err
+			}
+		default:
+			err = d.createTableValues(ctx, name, data.Table.Columns, func(values string) error {
+				data.Table.Values = values
+				return tval.Execute(section, data)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		d.endDataSection(name, section)
+
+		if !data.Data {
+			return nil
 		}
+		return tsetval.Execute(data.out, data)
+	})
+	if err != nil {
+		return err
 	}
 
-	// Set complete time
-	data.CompleteTime = time.Now().String()
-	tfoot.Execute(data.file, data)
+	if data.Structure {
+		for _, name := range list {
+			data.Table = tables[name]
+			if err = tindex.Execute(data.out, data); err != nil {
+				return err
+			}
+		}
 
-	return d.dropProcedure()
-}
+		for _, name := range list {
+			data.Table = tables[name]
+			if err = tfkey.Execute(data.out, data); err != nil {
+				return err
+			}
+		}
+
+		data.Table = nil
+		for _, data.Object = range objects {
+			tpl := tview
+			if data.Object.Kind == "FUNCTION" || data.Object.Kind == "PROCEDURE" {
+				tpl = tfunc
+			}
+
+			if err = tpl.Execute(data.out, data); err != nil {
+				return err
+			}
+		}
+	}
 
-func (d *Dumper) countPostgresRows(name string) (int64, error) {
-	var c sql.NullInt64
-	err := d.db.QueryRow("SELECT count(*) FROM public." + name).Scan(&c)
-	return c.Int64, err
+	if d.refresh && data.Data {
+		header := false
+		for _, data.Object = range data.objects {
+			if data.Object.Kind != "MATERIALIZED VIEW" {
+				continue
+			}
+
+			if !header {
+				header = true
+				if _, err = io.WriteString(data.out, "\n--\n-- Refresh materialized views\n--\n"); err != nil {
+					return err
+				}
+			}
+
+			if err = trefresh.Execute(data.out, data); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Set complete time
+	data.CompleteTime = time.Now().String()
+	return tfoot.Execute(data.out, data)
 }
 
 func getStringRows(rows *sql.Rows) ([]string, error) {
@@ -274,84 +526,460 @@ func getStringRows(rows *sql.Rows) ([]string, error) {
 	return list, rows.Err()
 }
 
-// getpostgrestables returns the table names from a PostgreSQL database.
-func (d *Dumper) getPostgresTables() ([]string, error) {
-	rows, err := d.db.Query(PG_SHOW_TABLES)
+// getPostgresInheritance returns the parents of the tables which inherit from others, including partitions,
+// and the partitioned tables.
+func (d *Dumper) getPostgresInheritance(ctx context.Context) (map[string][]string, map[string]bool, error) {
+	rows, err := d.querier().QueryContext(ctx, PG_SHOW_INHERITANCE)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer rows.Close()
+	parents := map[string][]string{}
+	partitioned := map[string]bool{}
+	for rows.Next() {
+		var name string
+		var isPartitioned bool
+		var parent sql.NullString
+		if err = rows.Scan(&name, &isPartitioned, &parent); err != nil {
+			return nil, nil, err
+		}
+
+		if isPartitioned {
+			partitioned[name] = true
+		}
+		if parent.Valid {
+			parents[name] = append(parents[name], parent.String)
+		}
+	}
+	return parents, partitioned, rows.Err()
+}
+
+// parentsFirst orders the tables in list so each comes after the tables it inherits from. Otherwise the order is kept.
+func parentsFirst(list []string, parents map[string][]string) []string {
+	index := make(map[string]int64, len(list))
+	keys := make([]int64, len(list))
+	for i, name := range list {
+		index[name] = int64(i)
+		keys[i] = int64(i)
+	}
+
+	deps := map[int64][]int64{}
+	for i, name := range list {
+		for _, p := range parents[name] {
+			if j, ok := index[p]; ok {
+				deps[int64(i)] = append(deps[int64(i)], j)
+			}
+		}
+	}
+
+	ordered := make([]string, 0, len(list))
+	for _, i := range dependencyOrder(keys, deps) {
+		ordered = append(ordered, list[i])
+	}
+	return ordered
+}
+
+// getPostgresTables returns the tables in the dumped schemas of a PostgreSQL database.
+func (d *Dumper) getPostgresTables(ctx context.Context) ([]pgName, error) {
+	rows, err := d.querier().QueryContext(ctx, PG_SHOW_TABLES)
 	if err != nil {
 		return nil, err
 	}
 
-	return getStringRows(rows)
+	defer rows.Close()
+	var list []pgName
+	for rows.Next() {
+		var t pgName
+		if err = rows.Scan(&t.Schema, &t.Name, &t.Qualified); err != nil {
+			return nil, err
+		}
+
+		if d.includeSchema(t.Schema) {
+			list = append(list, t)
+		}
+	}
+	return list, rows.Err()
 }
 
-func (d *Dumper) getPostgresSequences() ([]string, error) {
-	rows, err := d.db.Query(PG_GET_SEQ_LIST)
+// getPostgresViews returns the views and materialized views, each after the views it uses.
+func (d *Dumper) getPostgresViews(ctx context.Context) ([]*object, error) {
+	rows, err := d.querier().QueryContext(ctx, PG_SHOW_VIEWS)
 	if err != nil {
 		return nil, err
 	}
 
-	return getStringRows(rows)
+	defer rows.Close()
+	var views []*object
+	for rows.Next() {
+		var materialized bool
+		o := &object{Kind: "VIEW"}
+		if err = rows.Scan(&o.oid, &o.rel.Schema, &o.rel.Name, &o.Name, &materialized, &o.SQL); err != nil {
+			return nil, err
+		}
+
+		if !d.includeSchema(o.rel.Schema) {
+			continue
+		}
+
+		if materialized {
+			o.Kind = "MATERIALIZED VIEW"
+		}
+		o.SQL = strings.TrimRight(strings.TrimSpace(o.SQL), ";")
+		views = append(views, o)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(views) == 0 {
+		return views, nil
+	}
+
+	rows, err = d.querier().QueryContext(ctx, PG_VIEW_DEPS)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	deps := map[int64][]int64{}
+	for rows.Next() {
+		var view, dep int64
+		if err = rows.Scan(&view, &dep); err != nil {
+			return nil, err
+		}
+		deps[view] = append(deps[view], dep)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, v := range views {
+		v.deps = deps[v.oid]
+	}
+	return sortObjects(views), nil
 }
 
-// installProcedure installs the SQL generator plpgsql.
-func (d *Dumper) installProcedure() error {
-	_, err := d.db.Exec(PG_SHOW_TABLE_SQL)
-	return err
+// sortObjects returns the objects in list, each after the objects it depends on.
+func sortObjects(list []*object) []*object {
+	oids := make([]int64, len(list))
+	deps := make(map[int64][]int64, len(list))
+	for i, o := range list {
+		oids[i] = o.oid
+		deps[o.oid] = append(deps[o.oid], o.deps...)
+	}
+
+	sorted := make([]*object, 0, len(list))
+	for _, i := range dependencyOrder(oids, deps) {
+		sorted = append(sorted, list[i])
+	}
+	return sorted
 }
 
-// dropProcedure removes the SQL generator plpgsql.
-func (d *Dumper) dropProcedure() error {
-	// _, err := d.db.Exec(PG_DROP_SHOW_TABLE_SQL)
-	// return err
-	return nil
+// dependencyOrder returns the indexes of oids so that each comes after its dependencies in deps.
+// Otherwise the original order is kept.
+func dependencyOrder(oids []int64, deps map[int64][]int64) []int {
+	index := make(map[int64]int, len(oids))
+	for i, oid := range oids {
+		index[oid] = i
+	}
+
+	order := make([]int, 0, len(oids))
+	seen := make([]bool, len(oids))
+	var visit func(i int)
+	visit = func(i int) {
+		if seen[i] {
+			return
+		}
+		seen[i] = true
+		for _, dep := range deps[oids[i]] {
+			if j, ok := index[dep]; ok {
+				visit(j)
+			}
+		}
+		order = append(order, i)
+	}
+
+	for i := range oids {
+		visit(i)
+	}
+	return order
 }
 
-func (d *Dumper) createPostgresTable(name string) (*table, error) {
-	var err error
-	t := &table{Name: name}
+// getPostgresFunctions returns the functions and procedures in the database, each after the functions it uses.
+// Those which use relations, directly or through other functions, are returned separately, to be created after the tables.
+func (d *Dumper) getPostgresFunctions(ctx context.Context) ([]*object, []*object, error) {
+	rows, err := d.querier().QueryContext(ctx, PG_SHOW_FUNCTIONS)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	sequences, err := d.getPostgresSequences()
+	defer rows.Close()
+	var list []*object
+	for rows.Next() {
+		var schema string
+		o := &object{}
+		if err = rows.Scan(&o.oid, &schema, &o.Kind, &o.Name, &o.SQL); err != nil {
+			return nil, nil, err
+		}
+
+		if !d.includeSchema(schema) {
+			continue
+		}
+
+		o.SQL = strings.TrimSpace(o.SQL)
+		list = append(list, o)
+	}
+
+	if err = rows.Err(); err != nil || len(list) == 0 {
+		return list, nil, err
+	}
+
+	rows, err = d.querier().QueryContext(ctx, PG_FUNCTION_DEPS)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	buf := strings.Builder{}
-	for _, seq := range sequences {
-		s, err := d.createPostgresSequenceSQL(seq)
-		if err != nil {
-			return nil, err
+	defer rows.Close()
+	deps := map[int64][]int64{}
+	usesRelation := map[int64]bool{}
+	for rows.Next() {
+		var function, dep int64
+		var relation bool
+		if err = rows.Scan(&function, &dep, &relation); err != nil {
+			return nil, nil, err
+		}
+
+		deps[function] = append(deps[function], dep)
+		if relation {
+			usesRelation[function] = true
 		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
 
-		buf.WriteString(s)
+	// Functions using those which use relations have to wait for the relations too.
+	for changed := true; changed; {
+		changed = false
+		for _, o := range list {
+			for _, dep := range deps[o.oid] {
+				if usesRelation[dep] && !usesRelation[o.oid] {
+					usesRelation[o.oid] = true
+					changed = true
+				}
+			}
+		}
 	}
-	t.Sequences = buf.String()
 
-	if t.SQL, err = d.createPostgresTableSQL(name); err != nil {
+	for _, o := range list {
+		o.deps = deps[o.oid]
+	}
+
+	var early, late []*object
+	for _, o := range sortObjects(list) {
+		if usesRelation[o.oid] {
+			late = append(late, o)
+		} else {
+			early = append(early, o)
+		}
+	}
+	return early, late, nil
+}
+
+// getPostgresTableKey returns the columns to page through a table by, or nothing if it has no usable key.
+func (d *Dumper) getPostgresTableKey(ctx context.Context, name string) ([]string, error) {
+	rows, err := d.querier().QueryContext(ctx, PG_TABLE_KEY, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return chooseTableKey(rows)
+}
+
+func (d *Dumper) createPostgresTable(ctx context.Context, name string) (*table, error) {
+	var err error
+	t := &table{Name: name}
+
+	if t.Sequences, err = d.getPostgresSequences(ctx, PG_TABLE_SEQUENCES, name, name); err != nil {
+		return nil, err
+	}
+
+	if err = d.createPostgresTableSQL(ctx, t); err != nil {
+		return nil, err
+	}
+
+	if t.Indexes, err = d.getPostgresIndexes(ctx, name); err != nil {
 		return nil, err
 	}
 
+	t.SQL = d.createIfNotExists(t.SQL, "TABLE", "UNLOGGED TABLE")
+	for i, s := range t.Indexes {
+		if t.Partitioned {
+			s = strings.Replace(s, " ON ONLY ", " ON ", 1)
+		}
+		t.Indexes[i] = d.createIfNotExists(s, "INDEX", "UNIQUE INDEX")
+	}
 	return t, nil
 }
 
-func (d *Dumper) createPostgresSequenceSQL(name string) (string, error) {
-	var schema, datatype sql.NullString
-	var start, min, max, inc int64
-	err := d.db.QueryRow(PG_GET_SEQ, name).Scan(
-		&schema, &datatype, &start, &min, &max, &inc,
-	)
+// pgTruncate returns a statement which empties the tables in names together.
+// With the DDL in the dump, tables which don't exist yet are left out, as they are created empty.
+func pgTruncate(names []string, structure bool) string {
+	if !structure {
+		return "\nTRUNCATE TABLE ONLY " + strings.Join(names, ", ") + ";\n"
+	}
+
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = quoteString(name, true)
+	}
+	return "\nDO $$ DECLARE names text; BEGIN\n" +
+		"SELECT pg_catalog.string_agg(n, ', ') INTO names FROM pg_catalog.unnest(ARRAY[" + strings.Join(quoted, ", ") + "]) n\n" +
+		"WHERE pg_catalog.to_regclass(n) IS NOT NULL;\n" +
+		"IF names IS NOT NULL THEN EXECUTE 'TRUNCATE TABLE ONLY ' || names; END IF;\n" +
+		"END $$;\n"
+}
+
+// identityOptions returns the sequence options of an identity column, so they are kept on restore.
+func identityOptions(sequences []*sequence, column string) string {
+	for _, s := range sequences {
+		if s.Identity && s.Column == column {
+			return " (" + s.Options + ")"
+		}
+	}
+	return ""
+}
+
+// createPostgresTableSQL builds the CREATE TABLE statement of t from the system catalogs.
+// Check constraints are part of the statement, while the other constraints are kept in t to be added after the data.
+// Identity columns keep the options of their sequences, which must be in t.Sequences.
+// Tables with GENERATED ALWAYS identity columns are marked to be restored with OVERRIDING SYSTEM VALUE,
+// and tables with generated columns get the list of the other columns to restore.
+// Partitions are created as tables and then attached, and inherited tables list their parents.
+// Their columns are listed in the data, as their order may differ from a newly created table.
+func (d *Dumper) createPostgresTableSQL(ctx context.Context, t *table) error {
+	var unlogged bool
+	var partkey, bound sql.NullString
+	var parents string
+	err := d.querier().QueryRowContext(ctx, PG_TABLE_INFO, t.Name).Scan(&unlogged, &t.Partitioned, &partkey, &bound, &parents)
+	if err != nil {
+		return err
+	}
+
+	rows, err := d.querier().QueryContext(ctx, PG_TABLE_COLUMNS, t.Name)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+	var defs, columns []string
+	generatedColumns := false
+	for rows.Next() {
+		var column, datatype string
+		var collation, def, identity, generated sql.NullString
+		var notnull bool
+		if err = rows.Scan(&column, &datatype, &collation, &def, &identity, &generated, &notnull); err != nil {
+			return err
+		}
+
+		s := column + " " + datatype
+		if collation.Valid {
+			s += " COLLATE " + collation.String
+		}
+
+		switch {
+		case generated.String == "s":
+			s += " GENERATED ALWAYS AS (" + def.String + ") STORED"
+			generatedColumns = true
+		case identity.String == "a":
+			s += " GENERATED ALWAYS AS IDENTITY" + identityOptions(t.Sequences, column)
+			t.Override = true
+		case identity.String == "d":
+			s += " GENERATED BY DEFAULT AS IDENTITY" + identityOptions(t.Sequences, column)
+		case def.Valid:
+			s += " DEFAULT " + def.String
+		}
+
+		if notnull {
+			s += " NOT NULL"
+		}
+		defs = append(defs, s)
+		if generated.String == "" {
+			columns = append(columns, column)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(defs) == 0 {
+		return errors.New("No columns in table " + t.Name + ".")
+	}
+
+	if generatedColumns || parents != "" {
+		t.Columns = columns
+	}
+
+	rows, err = d.querier().QueryContext(ctx, PG_TABLE_CONSTRAINTS, t.Name)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	s := fmt.Sprintf(
-		"CREATE SEQUENCE %s.%s\n\tINCREMENT %d\n\tSTART %d\n\tMINVALUE %d\n\tMAXVALUE %d\n\tCACHE 1;\n\n",
-		schema.String, name, inc, start, min, max,
-	)
-	return s, nil
+	defer rows.Close()
+	for rows.Next() {
+		var kind, constraint, def string
+		if err = rows.Scan(&kind, &constraint, &def); err != nil {
+			return err
+		}
+
+		switch kind {
+		case "c":
+			defs = append(defs, "CONSTRAINT "+constraint+" "+def)
+		case "f":
+			t.ForeignKeys = append(t.ForeignKeys, constraint+" "+def)
+		default:
+			t.Constraints = append(t.Constraints, constraint+" "+def)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	create := "CREATE TABLE "
+	if unlogged {
+		create = "CREATE UNLOGGED TABLE "
+	}
+
+	t.SQL = create + t.Name + " (\n    " + strings.Join(defs, ",\n    ") + "\n)"
+	switch {
+	case bound.Valid:
+		t.Attach = "ALTER TABLE ONLY " + parents + " ATTACH PARTITION " + t.Name + " " + bound.String
+		if d.ifNotExists {
+			t.Attach = "DO $$ BEGIN\nIF NOT EXISTS (SELECT 1 FROM pg_catalog.pg_inherits WHERE inhrelid = " + quoteString(t.Name, true) + "::regclass) THEN\n" +
+				t.Attach + ";\nEND IF;\nEND $$"
+		}
+	case parents != "":
+		t.SQL += "\nINHERITS (" + parents + ")"
+	}
+
+	if partkey.Valid {
+		t.SQL += "\nPARTITION BY " + partkey.String
+	}
+	return nil
 }
 
-func (d *Dumper) createPostgresTableSQL(name string) (string, error) {
-	var s sql.NullString
-	err := d.db.QueryRow("select show_create_table($1);", name).Scan(&s)
-	return s.String, err
+// getPostgresIndexes returns the CREATE INDEX statements of a table, except for indexes of constraints.
+func (d *Dumper) getPostgresIndexes(ctx context.Context, name string) ([]string, error) {
+	rows, err := d.querier().QueryContext(ctx, PG_TABLE_INDEXES, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return getStringRows(rows)
 }
@@ -0,0 +1,187 @@
+package sqldump
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"sync"
+	"time"
+)
+
+// Estimated row counts from the table statistics, read only when an observer is set.
+const (
+	PG_ESTIMATE_ROWS = `SELECT c.reltuples::bigint FROM pg_catalog.pg_class c WHERE c.oid = $1::regclass`
+	MY_ESTIMATE_ROWS = `SELECT TABLE_ROWS FROM information_schema.TABLES
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?`
+)
+
+// EventKind tells what an Event reports.
+type EventKind int
+
+const (
+	// DumpStarted is sent when the tables to dump are known.
+	DumpStarted EventKind = iota
+	// TableStarted is sent before the structure and data of a table are dumped.
+	TableStarted
+	// BatchWritten is sent after each INSERT statement, or block of COPY data, of a table.
+	BatchWritten
+	// TableFinished is sent when a table has been dumped.
+	TableFinished
+	// DumpFinished is sent when the dump ends, whether it succeeded or not.
+	DumpFinished
+)
+
+// Event reports the progress of a dump.
+type Event struct {
+	Kind EventKind
+	// Tables is the number of tables to dump, for DumpStarted.
+	Tables int
+	// Table is the name of the table, as given to Dump, for table and batch events.
+	Table string
+	// EstimatedRows is the row count from the table statistics for TableStarted, or -1 if unknown.
+	EstimatedRows int64
+	// Rows and Bytes are the rows and bytes of data in the batch, or in the whole table or dump when finished.
+	Rows  int64
+	Bytes int64
+	// Duration is the time the table or dump took, for the finished events.
+	Duration time.Duration
+	// Err is the error which ended the dump, if any, for DumpFinished.
+	Err error
+}
+
+// Observer receives the progress events of a dump.
+// Calls are serialized, also when tables are dumped in parallel, so the observer should return quickly.
+type Observer func(e Event)
+
+// SetObserver sets a function to receive progress events, to show a progress bar or export metrics.
+func (d *Dumper) SetObserver(o Observer) {
+	d.observer = o
+}
+
+// progress collects the counts of a dump for its events and manifest. It is shared with the workers of parallel dumps.
+type progress struct {
+	mu            sync.Mutex
+	start         time.Time
+	rows          int64
+	bytes         int64
+	tables        map[string]*Event
+	serverVersion string
+	order         []string
+	sections      map[string]ManifestTable
+	finished      map[string]ManifestTable
+}
+
+// startProgress begins reporting a dump, if an observer is set or a manifest is written.
+func (d *Dumper) startProgress() {
+	d.progress = nil
+	if d.observer != nil || d.manifest {
+		d.progress = &progress{
+			start:    time.Now(),
+			tables:   map[string]*Event{},
+			sections: map[string]ManifestTable{},
+			finished: map[string]ManifestTable{},
+		}
+	}
+}
+
+// startTables reports the tables to dump, in order.
+func (d *Dumper) startTables(list []string) {
+	if d.progress != nil {
+		d.progress.order = list
+	}
+	d.observe(Event{Kind: DumpStarted, Tables: len(list)})
+}
+
+// observe sends an event to the observer, adding batch counts to the totals of their table and the dump.
+func (d *Dumper) observe(e Event) {
+	p := d.progress
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch e.Kind {
+	case TableStarted:
+		p.tables[e.Table] = &Event{Kind: TableFinished, Table: e.Table}
+	case BatchWritten:
+		if t := p.tables[e.Table]; t != nil {
+			t.Rows += e.Rows
+			t.Bytes += e.Bytes
+		}
+		p.rows += e.Rows
+		p.bytes += e.Bytes
+	case TableFinished:
+		t := p.sections[e.Table]
+		t.Name, t.Rows = e.Table, e.Rows
+		if t.SHA256 == "" {
+			t.SHA256 = emptySHA256
+		}
+		p.finished[e.Table] = t
+	case DumpFinished:
+		e.Rows, e.Bytes, e.Duration = p.rows, p.bytes, time.Since(p.start)
+	}
+
+	if d.observer != nil {
+		d.observer(e)
+	}
+}
+
+// observeTable wraps a table dump with the TableStarted and TableFinished events.
+func observeTable(fn func(ctx context.Context, d *Dumper, w io.Writer, name string) error) func(ctx context.Context, d *Dumper, w io.Writer, name string) error {
+	return func(ctx context.Context, d *Dumper, w io.Writer, name string) error {
+		start := time.Now()
+		estimate := int64(-1)
+		if d.observer != nil {
+			estimate = d.estimateRows(ctx, name)
+		}
+
+		d.observe(Event{Kind: TableStarted, Table: name, EstimatedRows: estimate})
+		if err := fn(ctx, d, w, name); err != nil {
+			return err
+		}
+
+		p := d.progress
+		p.mu.Lock()
+		e := p.tables[name]
+		delete(p.tables, name)
+		p.mu.Unlock()
+
+		e.Duration = time.Since(start)
+		d.observe(*e)
+		return nil
+	}
+}
+
+// estimateRows returns the number of rows of a table according to its statistics, or -1 if unknown.
+// The estimate is only informative, so a failure to get it doesn't fail the dump.
+func (d *Dumper) estimateRows(ctx context.Context, name string) int64 {
+	query := MY_ESTIMATE_ROWS
+	if d.pg {
+		query = PG_ESTIMATE_ROWS
+	}
+
+	var n sql.NullInt64
+	if err := d.querier().QueryRowContext(ctx, query, name).Scan(&n); err != nil || !n.Valid || n.Int64 < 0 {
+		return -1
+	}
+	return n.Int64
+}
+
+// countingWriter counts the bytes and lines written through it.
+type countingWriter struct {
+	w     io.Writer
+	bytes int64
+	lines int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.bytes += int64(n)
+	for _, b := range p[:n] {
+		if b == '\n' {
+			c.lines++
+		}
+	}
+	return n, err
+}
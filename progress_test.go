@@ -0,0 +1,74 @@
+package sqldump
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDumpObserver(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectQuery("^SELECT version()").WillReturnRows(sqlmock.NewRows([]string{"Version()"}).AddRow("test_version"))
+	mock.ExpectQuery("^SELECT @@max_allowed_packet$").WillReturnRows(maxPacketRows())
+	mock.ExpectQuery("^SHOW FULL TABLES$").WillReturnRows(fullTablesRows("t"))
+	mock.ExpectQuery("FROM information_schema.TRIGGERS").WillReturnRows(noTriggerRows())
+	mock.ExpectQuery("FROM information_schema.TABLES").WithArgs("t").WillReturnRows(sqlmock.NewRows([]string{"TABLE_ROWS"}).AddRow(4))
+	mock.ExpectQuery("^SHOW CREATE TABLE `t`$").WillReturnRows(sqlmock.NewRows([]string{"Table", "Create Table"}).
+		AddRow("t", "CREATE TABLE `t` (`id` int(11) NOT NULL)"))
+	mock.ExpectQuery("FROM information_schema.STATISTICS").WithArgs("t").WillReturnRows(noKeyRows())
+	mock.ExpectQuery("^SELECT (.+) FROM `t`$").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2).AddRow(3))
+
+	var events []Event
+	dumper := NewStreamDumper(db)
+	dumper.SetInsertLimits(0, 2)
+	dumper.SetObserver(func(e Event) {
+		if e.Kind == TableFinished || e.Kind == DumpFinished {
+			if e.Duration <= 0 {
+				t.Errorf("expected a duration for %#v", e)
+			}
+			e.Duration = 0
+		}
+		events = append(events, e)
+	})
+
+	var buf bytes.Buffer
+	if err := dumper.DumpTo(&buf); err != nil {
+		t.Fatalf("Error while dumping the database: %s", err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	batch := int64(len("('1'),('2')"))
+	expected := []Event{
+		{Kind: DumpStarted, Tables: 1},
+		{Kind: TableStarted, Table: "t", EstimatedRows: 4},
+		{Kind: BatchWritten, Table: "t", Rows: 2, Bytes: batch},
+		{Kind: BatchWritten, Table: "t", Rows: 1, Bytes: 5},
+		{Kind: TableFinished, Table: "t", Rows: 3, Bytes: batch + 5},
+		{Kind: DumpFinished, Rows: 3, Bytes: batch + 5},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, events)
+	}
+}
+
+func TestCountingWriter(t *testing.T) {
+	var buf strings.Builder
+	w := &countingWriter{w: &buf}
+	w.Write([]byte("1\ta\n2\t"))
+	w.Write([]byte("b\n"))
+	if w.lines != 2 || w.bytes != 8 || buf.String() != "1\ta\n2\tb\n" {
+		t.Fatalf("expected 2 lines and 8 bytes, got %d lines and %d bytes", w.lines, w.bytes)
+	}
+}
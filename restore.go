@@ -0,0 +1,175 @@
+package sqldump
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RestoreProgress is passed to the progress callback after each statement.
+type RestoreProgress struct {
+	// Statements executed so far, including failed ones.
+	Statements int64
+	// Bytes read from the dump so far.
+	Bytes int64
+	// Errors encountered so far.
+	Errors int
+	// Err is the error from the latest statement, if any.
+	Err error
+}
+
+// StatementError describes a statement which failed during a restore.
+type StatementError struct {
+	// Line in the dump on which the statement starts.
+	Line      int
+	Statement string
+	Err       error
+}
+
+func (e *StatementError) Error() string {
+	stmt := e.Statement
+	if len(stmt) > 80 {
+		stmt = stmt[:80] + "..."
+	}
+	return fmt.Sprintf("line %d: %s: %s", e.Line, e.Err.Error(), stmt)
+}
+
+func (e *StatementError) Unwrap() error {
+	return e.Err
+}
+
+// RestoreErrors is returned when statements failed while continuing on errors.
+type RestoreErrors []*StatementError
+
+func (e RestoreErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%d statements failed, first at %s", len(e), e[0].Error())
+}
+
+// Restorer loads dumps back into a database.
+type Restorer struct {
+	db       *sql.DB
+	cont     bool
+	progress func(RestoreProgress)
+	copyFrom CopyFromFunc
+}
+
+// CopyFromFunc runs a COPY ... FROM STDIN statement on a driver connection, as passed to (*sql.Conn).Raw,
+// reading the data from r.
+type CopyFromFunc func(ctx context.Context, driverConn interface{}, r io.Reader, query string) error
+
+// NewRestorer creates a restorer for a MySQL/MariaDB or PostgreSQL database.
+func NewRestorer(db *sql.DB) *Restorer {
+	return &Restorer{db: db}
+}
+
+// SetContinueOnError makes the restorer execute the remaining statements after a failure.
+// All failures are returned as RestoreErrors when the restore completes.
+// The default is to stop at the first failed statement.
+func (r *Restorer) SetContinueOnError(cont bool) {
+	r.cont = cont
+}
+
+// SetProgress sets a callback which is called after each statement.
+func (r *Restorer) SetProgress(fn func(RestoreProgress)) {
+	r.progress = fn
+}
+
+// SetCopyFrom lets the data of COPY statements be loaded by the driver, as database/sql has no support for COPY FROM STDIN.
+// Without it, the data is loaded with INSERT statements.
+// With pgx it can be set up like this:
+//
+//	restorer.SetCopyFrom(func(ctx context.Context, dc interface{}, r io.Reader, query string) error {
+//		_, err := dc.(*stdlib.Conn).Conn().PgConn().CopyFrom(ctx, r, query)
+//		return err
+//	})
+func (r *Restorer) SetCopyFrom(fn CopyFromFunc) {
+	r.copyFrom = fn
+}
+
+// Restore executes the statements of a dump read from rd in order, using default options.
+func Restore(ctx context.Context, db *sql.DB, rd io.Reader) error {
+	return NewRestorer(db).Restore(ctx, rd)
+}
+
+// Restore executes the statements of a dump read from rd in order.
+// All statements run on the same connection, so session settings from the dump header apply to the whole restore.
+// The connection is closed afterwards rather than returned to the pool, as the dump doesn't reset those settings.
+func (r *Restorer) Restore(ctx context.Context, rd io.Reader) error {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer discardConn(conn)
+	var version sql.NullString
+	if err = conn.QueryRowContext(ctx, "SELECT version()").Scan(&version); err != nil {
+		return err
+	}
+
+	s := newStatementScanner(rd, strings.Contains(version.String, "PostgreSQL"))
+	var errs RestoreErrors
+	p := RestoreProgress{}
+	for {
+		stmt, line, err := s.next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		p.Statements++
+		p.Bytes = s.bytes
+		p.Err = nil
+		if s.copy != nil {
+			err = r.loadCopy(ctx, conn, stmt, s.copy)
+		} else {
+			_, err = conn.ExecContext(ctx, stmt)
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			p.Err = &StatementError{Line: line, Statement: stmt, Err: err}
+			p.Errors++
+			if !r.cont {
+				r.report(p)
+				return p.Err
+			}
+
+			errs = append(errs, p.Err.(*StatementError))
+		}
+
+		r.report(p)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// loadCopy loads the data of a COPY statement.
+func (r *Restorer) loadCopy(ctx context.Context, conn *sql.Conn, stmt string, data io.Reader) error {
+	if r.copyFrom == nil {
+		return copyInserts(ctx, conn, stmt, data)
+	}
+
+	return conn.Raw(func(dc interface{}) error {
+		return r.copyFrom(ctx, dc, data, stmt)
+	})
+}
+
+func (r *Restorer) report(p RestoreProgress) {
+	if r.progress != nil {
+		r.progress(p)
+	}
+}
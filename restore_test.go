@@ -0,0 +1,167 @@
+package sqldump
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func splitAll(t *testing.T, input string, pg bool) []string {
+	s := newStatementScanner(strings.NewReader(input), pg)
+	list := []string{}
+	for {
+		stmt, _, err := s.next()
+		if err == io.EOF {
+			return list
+		}
+
+		if err != nil {
+			t.Fatalf("error was not expected while splitting: %s", err)
+		}
+		list = append(list, stmt)
+	}
+}
+
+func TestSplitMySQL(t *testing.T) {
+	input := `-- Go SQL Dump
+--
+/*!40101 SET NAMES utf8 */;
+/* plain; comment */ DROP TABLE IF EXISTS ` + "`a;b`" + `;
+INSERT INTO t VALUES ('it''s; fine','back\\slash\'; quote',"dq;","esc\"; dq");
+-- trailing comment;
+SELECT 1--2;
+SELECT 3`
+
+	expected := []string{
+		"/*!40101 SET NAMES utf8 */",
+		"/* plain; comment */ DROP TABLE IF EXISTS `a;b`",
+		`INSERT INTO t VALUES ('it''s; fine','back\\slash\'; quote',"dq;","esc\"; dq")`,
+		"SELECT 1--2",
+		"SELECT 3",
+	}
+
+	result := splitAll(t, input, false)
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+}
+
+func TestSplitDelimiter(t *testing.T) {
+	input := `DROP TRIGGER IF EXISTS t1;
+DELIMITER ;;
+CREATE TRIGGER t1 BEFORE INSERT ON a FOR EACH ROW BEGIN SET NEW.x = ';;'; SET NEW.y = 1; END ;;
+delimiter ;
+SELECT 1;
+DELIMITER $$
+SELECT 2$$`
+
+	expected := []string{
+		"DROP TRIGGER IF EXISTS t1",
+		"CREATE TRIGGER t1 BEFORE INSERT ON a FOR EACH ROW BEGIN SET NEW.x = ';;'; SET NEW.y = 1; END",
+		"SELECT 1",
+		"SELECT 2",
+	}
+
+	result := splitAll(t, input, false)
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+}
+
+func TestSplitPostgres(t *testing.T) {
+	input := `CREATE FUNCTION f() RETURNS int AS
+$BODY$
+BEGIN
+	RETURN 1; -- $x$ isn't closing
+END;
+$BODY$ LANGUAGE plpgsql;
+CREATE OR REPLACE PROCEDURE p(a int) LANGUAGE sql
+BEGIN ATOMIC
+	INSERT INTO t VALUES (a);
+	SELECT CASE WHEN a > 0 THEN 1 END;
+END;
+BEGIN;
+INSERT INTO t VALUES ('a\', 'b;c', E'd\'e;', $$f;g$$, $1);
+;;
+SELECT 'x'--comment;
+;`
+
+	expected := []string{
+		"CREATE FUNCTION f() RETURNS int AS\n$BODY$\nBEGIN\n\tRETURN 1; -- $x$ isn't closing\nEND;\n$BODY$ LANGUAGE plpgsql",
+		"CREATE OR REPLACE PROCEDURE p(a int) LANGUAGE sql\nBEGIN ATOMIC\n\tINSERT INTO t VALUES (a);\n\tSELECT CASE WHEN a > 0 THEN 1 END;\nEND",
+		"BEGIN",
+		`INSERT INTO t VALUES ('a\', 'b;c', E'd\'e;', $$f;g$$, $1)`,
+		"SELECT 'x'",
+	}
+
+	result := splitAll(t, input, true)
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+}
+
+func TestRestoreContinueOnError(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT version()").WillReturnRows(sqlmock.NewRows([]string{"version()"}).AddRow("8.0.30"))
+	mock.ExpectExec("/*!40101 SET NAMES utf8 */").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO t VALUES (1)").WillReturnError(errors.New("duplicate"))
+	mock.ExpectExec("INSERT INTO t VALUES (2)").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	r := NewRestorer(db)
+	r.SetContinueOnError(true)
+	var last RestoreProgress
+	r.SetProgress(func(p RestoreProgress) {
+		last = p
+	})
+
+	err = r.Restore(context.Background(), strings.NewReader("/*!40101 SET NAMES utf8 */;\nINSERT INTO t VALUES (1);\nINSERT INTO t VALUES (2);\n"))
+	errs, ok := err.(RestoreErrors)
+	if !ok || len(errs) != 1 || errs[0].Line != 2 {
+		t.Fatalf("expected one statement error on line 2, got %#v", err)
+	}
+
+	if last.Statements != 3 || last.Errors != 1 {
+		t.Fatalf("unexpected progress %#v", last)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	if s := db.Stats(); s.OpenConnections != 0 {
+		t.Errorf("expected the restore connection to be closed, got %d open", s.OpenConnections)
+	}
+}
+
+func TestRestoreStopOnError(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT version()").WillReturnRows(sqlmock.NewRows([]string{"version()"}).AddRow("PostgreSQL 14.5"))
+	mock.ExpectExec("INSERT INTO t VALUES (1)").WillReturnError(errors.New("duplicate"))
+
+	err = Restore(context.Background(), db, strings.NewReader("INSERT INTO t VALUES (1);\nINSERT INTO t VALUES (2);\n"))
+	var se *StatementError
+	if !errors.As(err, &se) || se.Line != 1 {
+		t.Fatalf("expected a statement error on line 1, got %#v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
@@ -0,0 +1,116 @@
+package sqldump
+
+import (
+	"context"
+	"errors"
+)
+
+// PG_SHOW_SCHEMAS lists the schemas of a PostgreSQL database, except system and extension schemas.
+const PG_SHOW_SCHEMAS = `SELECT n.nspname, pg_catalog.quote_ident(n.nspname)
+FROM pg_catalog.pg_namespace n
+WHERE n.nspname <> 'information_schema' AND n.nspname !~ '^pg_'
+AND NOT EXISTS (SELECT 1 FROM pg_catalog.pg_depend e WHERE e.objid = n.oid AND e.deptype = 'e')
+ORDER BY 1`
+
+const pgschema = `{{ with .Object }}CREATE SCHEMA IF NOT EXISTS {{ .Name }};
+{{ end }}`
+
+// SetSchemas limits PostgreSQL dumps to the given schemas. By default all schemas are dumped.
+func (d *Dumper) SetSchemas(schemas ...string) {
+	d.schemas = schemas
+}
+
+// SetExcludeSchemas leaves the given schemas out of PostgreSQL dumps.
+func (d *Dumper) SetExcludeSchemas(schemas ...string) {
+	d.excludeSchemas = schemas
+}
+
+// includeSchema reports whether objects in schema are dumped.
+func (d *Dumper) includeSchema(schema string) bool {
+	if len(d.schemas) > 0 && !contains(d.schemas, schema) {
+		return false
+	}
+	return !contains(d.excludeSchemas, schema)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// pgName is a PostgreSQL table or view in its schema.
+type pgName struct {
+	Schema string
+	Name   string
+	// Qualified is the quoted name with its schema, as used in SQL.
+	Qualified string
+}
+
+// matches reports whether a table name given to Dump refers to n.
+// Names may be bare, qualified with the schema, or quoted as in SQL.
+func (n pgName) matches(filter string) bool {
+	return filter == n.Name || filter == n.Schema+"."+n.Name || filter == n.Qualified
+}
+
+// getPostgresSchemas returns the quoted names of the schemas to dump.
+func (d *Dumper) getPostgresSchemas(ctx context.Context) ([]string, error) {
+	rows, err := d.querier().QueryContext(ctx, PG_SHOW_SCHEMAS)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	var list []string
+	for rows.Next() {
+		var name, quoted string
+		if err = rows.Scan(&name, &quoted); err != nil {
+			return nil, err
+		}
+
+		if d.includeSchema(name) {
+			list = append(list, quoted)
+		}
+	}
+	return list, rows.Err()
+}
+
+// selectPostgres resolves the table names given to Dump to the tables and views they refer to, in order.
+// A bare name selects the tables or views with that name in every dumped schema.
+func selectPostgres(filters []string, tables []pgName, views []*object) ([]string, []*object, error) {
+	var list []string
+	var selected []*object
+	for _, f := range filters {
+		found := false
+		for _, t := range tables {
+			if t.matches(f) && !contains(list, t.Qualified) {
+				list = append(list, t.Qualified)
+				found = true
+			}
+		}
+
+		for _, v := range views {
+			if v.rel.matches(f) {
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, nil, errors.New("No table or view named " + f + ".")
+		}
+	}
+
+	// Views keep their dependency order.
+	for _, v := range views {
+		for _, f := range filters {
+			if v.rel.matches(f) {
+				selected = append(selected, v)
+				break
+			}
+		}
+	}
+	return list, selected, nil
+}
@@ -0,0 +1,85 @@
+package sqldump
+
+import (
+	"reflect"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestIncludeSchema(t *testing.T) {
+	d := NewStreamDumper(nil)
+	if !d.includeSchema("public") {
+		t.Fatalf("expected every schema to be included by default")
+	}
+
+	d.SetSchemas("public", "app")
+	d.SetExcludeSchemas("app")
+	for schema, expected := range map[string]bool{"public": true, "app": false, "audit": false} {
+		if result := d.includeSchema(schema); result != expected {
+			t.Errorf("expected %v for schema %s, got %v", expected, schema, result)
+		}
+	}
+}
+
+func TestSelectPostgres(t *testing.T) {
+	tables := []pgName{
+		{"app", "items", "app.items"},
+		{"public", "items", "public.items"},
+		{"public", "Users", `public."Users"`},
+	}
+	views := []*object{
+		{Name: "public.v2", rel: pgName{"public", "v2", "public.v2"}},
+		{Name: "public.v1", rel: pgName{"public", "v1", "public.v1"}},
+	}
+
+	list, selected, err := selectPostgres([]string{"v1", "public.Users", "items", "v2"}, tables, views)
+	if err != nil {
+		t.Fatalf("error was not expected while selecting tables: %s", err)
+	}
+
+	expected := []string{`public."Users"`, "app.items", "public.items"}
+	if !reflect.DeepEqual(list, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, list)
+	}
+
+	if len(selected) != 2 || selected[0] != views[0] || selected[1] != views[1] {
+		t.Fatalf("expected views in dependency order, got %#v", selected)
+	}
+
+	if _, _, err = selectPostgres([]string{"audit.items"}, tables, views); err == nil {
+		t.Fatalf("expected an error for an unknown table")
+	}
+}
+
+func TestCreateTableValuesQuotedKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectQuery("FROM pg_catalog.pg_index").WithArgs(`app."Items"`).WillReturnRows(
+		sqlmock.NewRows([]string{"index", "attname", "attnotnull"}).AddRow(`app."Items_pkey"`, "Id", true))
+	mock.ExpectQuery(`^SELECT \* FROM ONLY app."Items" ORDER BY "Id" LIMIT 2$`).WillReturnRows(
+		sqlmock.NewRows([]string{"Id"}).AddRow(1).AddRow(2))
+	mock.ExpectQuery(`^SELECT \* FROM ONLY app."Items" WHERE "Id" > \$1 ORDER BY "Id" LIMIT 2$`).WithArgs("2").WillReturnRows(
+		sqlmock.NewRows([]string{"Id"}))
+
+	d := NewStreamDumper(db)
+	d.pg = true
+	d.SetMaxRows(2)
+	result, err := collectTableValues(d, `app."Items"`)
+	if err != nil {
+		t.Fatalf("error was not expected while reading values: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	if expected := "('1'),('2')"; result != expected {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+}
@@ -0,0 +1,121 @@
+package sqldump
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// List the sequences owned by the columns of a table, including those of identity columns.
+	PG_TABLE_SEQUENCES = `SELECT s.schemaname, pg_catalog.quote_ident(s.schemaname) || '.' || pg_catalog.quote_ident(s.sequencename),
+a.attname, pg_catalog.quote_ident(a.attname), d.deptype = 'i', s.data_type::text, s.start_value, s.min_value, s.max_value, s.increment_by, s.cycle, s.cache_size, s.last_value,
+pg_catalog.has_sequence_privilege(c.oid, 'SELECT, USAGE')
+FROM pg_catalog.pg_depend d
+JOIN pg_catalog.pg_class c ON c.oid = d.objid AND c.relkind = 'S'
+JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+JOIN pg_catalog.pg_sequences s ON s.schemaname = n.nspname AND s.sequencename = c.relname
+JOIN pg_catalog.pg_attribute a ON a.attrelid = d.refobjid AND a.attnum = d.refobjsubid
+WHERE d.classid = 'pg_catalog.pg_class'::regclass AND d.refclassid = 'pg_catalog.pg_class'::regclass
+AND d.refobjid = $1::regclass AND d.deptype IN ('a', 'i')
+ORDER BY 2`
+
+	// List the sequences which belong to no table or extension.
+	PG_SHOW_SEQUENCES = `SELECT s.schemaname, pg_catalog.quote_ident(s.schemaname) || '.' || pg_catalog.quote_ident(s.sequencename),
+NULL, NULL, false, s.data_type::text, s.start_value, s.min_value, s.max_value, s.increment_by, s.cycle, s.cache_size, s.last_value,
+pg_catalog.has_sequence_privilege(c.oid, 'SELECT, USAGE')
+FROM pg_catalog.pg_sequences s
+JOIN pg_catalog.pg_namespace n ON n.nspname = s.schemaname
+JOIN pg_catalog.pg_class c ON c.relnamespace = n.oid AND c.relname = s.sequencename
+WHERE NOT EXISTS (SELECT 1 FROM pg_catalog.pg_depend d
+	WHERE d.classid = 'pg_catalog.pg_class'::regclass AND d.objid = c.oid AND d.deptype IN ('a', 'i', 'e'))
+ORDER BY 2`
+
+	pgsequence = `{{ with .Sequence }}
+--
+-- Sequence {{ comment .Name }}
+--
+{{ if $.Structure }}{{ if $.Drop }}DROP SEQUENCE IF EXISTS {{ .Name }};
+{{ end }}{{ .SQL }};
+{{ end }}{{ if $.Data }}{{ .SetVal }};
+{{ end }}{{ end }}`
+)
+
+// sequence is a PostgreSQL sequence, either standalone or owned by a table column.
+type sequence struct {
+	// Name is the quoted name with its schema.
+	Name string
+	// Column is the quoted name of the owning column, if any.
+	Column string
+	// Identity sequences are created along with their column.
+	Identity bool
+	// SQL creates the sequence.
+	SQL string
+	// Options are the options of the sequence without its type, as given to identity columns.
+	Options string
+	// SetVal restores the current value of the sequence.
+	SetVal string
+}
+
+// getPostgresSequences returns the sequences read by query, which takes the arguments in args.
+// The current value is restored through the owning column of table, if one is given.
+func (d *Dumper) getPostgresSequences(ctx context.Context, query, table string, args ...interface{}) ([]*sequence, error) {
+	rows, err := d.querier().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	var list []*sequence
+	for rows.Next() {
+		var schema, datatype string
+		var column, quoted sql.NullString
+		var start, min, max, inc, cache int64
+		var cycle bool
+		var last sql.NullInt64
+		var readable bool
+		s := &sequence{}
+		err = rows.Scan(&schema, &s.Name, &column, &quoted, &s.Identity, &datatype, &start, &min, &max, &inc, &cycle, &cache, &last, &readable)
+		if err != nil {
+			return nil, err
+		}
+
+		if !d.includeSchema(schema) {
+			continue
+		}
+
+		options := fmt.Sprintf("START WITH %d\n\tINCREMENT BY %d\n\tMINVALUE %d\n\tMAXVALUE %d\n\tCACHE %d", start, inc, min, max, cache)
+		if cycle {
+			options += "\n\tCYCLE"
+		}
+		s.SQL = d.createIfNotExists("CREATE SEQUENCE "+s.Name+"\n\tAS "+datatype+"\n\t"+options, "SEQUENCE")
+		s.Options = strings.ReplaceAll(options, "\n\t", " ")
+
+		// The last value is also null without the privilege to read it, and guessing it would make ids collide after a restore.
+		if !readable && d.content != SchemaOnly {
+			return nil, errors.New("No privilege to read the value of sequence " + s.Name + ", grant SELECT on it.")
+		}
+
+		// A readable sequence without a last value hasn't been used, so the next value is its start.
+		value, called := start, false
+		if last.Valid {
+			value, called = last.Int64, true
+		}
+
+		// Identity sequences get their name on restore, so they are found through their column.
+		target := quoteString(s.Name, true)
+		if column.Valid {
+			s.Column = quoted.String
+			if s.Identity {
+				target = "pg_catalog.pg_get_serial_sequence(" + quoteString(table, true) + ", " + quoteString(column.String, true) + ")"
+			}
+		}
+
+		s.SetVal = "SELECT pg_catalog.setval(" + target + ", " + strconv.FormatInt(value, 10) + ", " + strconv.FormatBool(called) + ")"
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
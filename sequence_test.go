@@ -0,0 +1,112 @@
+package sqldump
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+	"text/template"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func sequenceRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"schemaname", "name", "attname", "quoted", "identity", "data_type",
+		"start_value", "min_value", "max_value", "increment_by", "cycle", "cache_size", "last_value", "readable"})
+}
+
+func TestGetPostgresSequences(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectQuery("d.deptype IN \\('a', 'i'\\)").WithArgs("public.items").WillReturnRows(sequenceRows().
+		AddRow("public", "public.items_id_seq", "id", "id", true, "bigint", 1, 1, 9223372036854775807, 1, false, 1, 42, true).
+		AddRow("public", `public."items_Code_seq"`, "Code", `"Code"`, false, "integer", 100, 1, 2147483647, 10, true, 1, nil, true))
+
+	d := NewStreamDumper(db)
+	d.pg = true
+	result, err := d.getPostgresSequences(context.Background(), PG_TABLE_SEQUENCES, "public.items", "public.items")
+	if err != nil {
+		t.Fatalf("error was not expected while reading sequences: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	expected := []*sequence{
+		{
+			Name:     "public.items_id_seq",
+			Column:   "id",
+			Identity: true,
+			SQL:      "CREATE SEQUENCE public.items_id_seq\n\tAS bigint\n\tSTART WITH 1\n\tINCREMENT BY 1\n\tMINVALUE 1\n\tMAXVALUE 9223372036854775807\n\tCACHE 1",
+			Options:  "START WITH 1 INCREMENT BY 1 MINVALUE 1 MAXVALUE 9223372036854775807 CACHE 1",
+			SetVal:   "SELECT pg_catalog.setval(pg_catalog.pg_get_serial_sequence('public.items', 'id'), 42, true)",
+		},
+		{
+			Name:    `public."items_Code_seq"`,
+			Column:  `"Code"`,
+			SQL:     "CREATE SEQUENCE public.\"items_Code_seq\"\n\tAS integer\n\tSTART WITH 100\n\tINCREMENT BY 10\n\tMINVALUE 1\n\tMAXVALUE 2147483647\n\tCACHE 1\n\tCYCLE",
+			Options: "START WITH 100 INCREMENT BY 10 MINVALUE 1 MAXVALUE 2147483647 CACHE 1 CYCLE",
+			SetVal:  `SELECT pg_catalog.setval('public."items_Code_seq"', 100, false)`,
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+
+	// Only the serial sequence is created with the table; the identity sequence comes with its column.
+	data := dump{Structure: true, Data: true, Drop: true, Table: &table{Name: "public.items", SQL: "CREATE TABLE public.items ()", Sequences: result}}
+	var buf bytes.Buffer
+	for _, tpl := range []string{pgtableheader, pgtablesql, pgsetval} {
+		if err := template.Must(template.New("").Funcs(templateFuncs).Parse(tpl)).Execute(&buf, data); err != nil {
+			t.Fatalf("error was not expected while executing the template: %s", err)
+		}
+	}
+
+	statements := splitAll(t, buf.String(), true)
+	expectedStatements := []string{
+		`DROP SEQUENCE IF EXISTS public."items_Code_seq"`,
+		expected[1].SQL,
+		"CREATE TABLE public.items ()",
+		`ALTER SEQUENCE public."items_Code_seq" OWNED BY public.items."Code"`,
+		expected[0].SetVal,
+		expected[1].SetVal,
+	}
+	if !reflect.DeepEqual(statements, expectedStatements) {
+		t.Fatalf("expected %#v, got %#v", expectedStatements, statements)
+	}
+}
+
+func TestGetPostgresSequencesUnreadable(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	row := []driver.Value{"public", "public.items_id_seq", nil, nil, false, "bigint", 1, 1, 9223372036854775807, 1, false, 1, nil, false}
+	mock.ExpectQuery("NULL, NULL, false").WillReturnRows(sequenceRows().AddRow(row...))
+	mock.ExpectQuery("NULL, NULL, false").WillReturnRows(sequenceRows().AddRow(row...))
+
+	d := NewStreamDumper(db)
+	d.pg = true
+	if _, err = d.getPostgresSequences(context.Background(), PG_SHOW_SEQUENCES, ""); err == nil {
+		t.Fatalf("expected an error for a sequence whose value can't be read")
+	}
+
+	d.SetContent(SchemaOnly)
+	if _, err = d.getPostgresSequences(context.Background(), PG_SHOW_SEQUENCES, ""); err != nil {
+		t.Fatalf("error was not expected without data: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
@@ -0,0 +1,102 @@
+package sqldump
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+)
+
+// querier is implemented by *sql.DB, *sql.Conn and *sql.Tx.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// SetConsistent makes dumps point-in-time consistent.
+// All queries of a dump then run in one transaction on a single connection:
+// START TRANSACTION WITH CONSISTENT SNAPSHOT on MySQL/MariaDB, and
+// REPEATABLE READ READ ONLY on PostgreSQL.
+// This only gives a consistent dump for transactional tables (InnoDB on MySQL).
+func (d *Dumper) SetConsistent(consistent bool) {
+	d.consistent = consistent
+}
+
+// querier returns what queries run on: the session connection during a PostgreSQL or consistent dump, otherwise the pool.
+func (d *Dumper) querier() querier {
+	if d.conn != nil {
+		return d.conn
+	}
+	return d.db
+}
+
+// usesSession reports whether a dump runs its queries on one pinned connection.
+// PostgreSQL dumps need one with an empty search_path, so the server qualifies every name it prints.
+func (d *Dumper) usesSession() bool {
+	return d.consistent || d.pg
+}
+
+// beginSession pins a connection and prepares it for dumping.
+// If the dump is consistent, the snapshot transaction is started on it.
+// On PostgreSQL, a snapshot exported by another transaction is imported if one is given.
+func (d *Dumper) beginSession(ctx context.Context, snapshot string) error {
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	var stmts []string
+	if d.pg {
+		stmts = []string{"SELECT pg_catalog.set_config('search_path', '', false)"}
+		if d.consistent {
+			stmts = append(stmts, "BEGIN TRANSACTION ISOLATION LEVEL REPEATABLE READ READ ONLY")
+		}
+		if snapshot != "" {
+			stmts = append(stmts, "SET TRANSACTION SNAPSHOT "+quoteString(snapshot, true))
+		}
+	} else if d.consistent {
+		stmts = []string{
+			"SET TRANSACTION ISOLATION LEVEL REPEATABLE READ",
+			"START TRANSACTION WITH CONSISTENT SNAPSHOT",
+		}
+	}
+
+	for _, stmt := range stmts {
+		if _, err = conn.ExecContext(ctx, stmt); err != nil {
+			discardConn(conn)
+			return err
+		}
+	}
+
+	d.conn = conn
+	return nil
+}
+
+// endSession ends the snapshot transaction, if any, and releases the connection.
+// A PostgreSQL connection is closed rather than returned to the pool, as its search_path is empty,
+// and so is any connection whose transaction didn't end cleanly.
+func (d *Dumper) endSession(ctx context.Context) error {
+	if d.conn == nil {
+		return nil
+	}
+
+	var err error
+	if d.consistent {
+		_, err = d.conn.ExecContext(ctx, "COMMIT")
+	}
+	if d.pg || err != nil {
+		discardConn(d.conn)
+	} else {
+		err = d.conn.Close()
+	}
+	d.conn = nil
+	return err
+}
+
+// discardConn closes the connection of conn instead of returning it to the pool,
+// so the session settings made on it can't leak into other users of the pool.
+func discardConn(conn *sql.Conn) {
+	conn.Raw(func(interface{}) error {
+		return driver.ErrBadConn
+	})
+}
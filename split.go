@@ -0,0 +1,382 @@
+package sqldump
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// statementScanner splits a dump into individual SQL statements.
+// It understands quoted strings and identifiers, line and block comments
+// (MySQL /*! ... */ conditional comments are kept as part of the statement),
+// and PostgreSQL dollar quoting, so semicolons inside any of them don't end a statement.
+// Neither do semicolons in the BEGIN ATOMIC ... END body of a PostgreSQL function or procedure.
+// The mysql client's DELIMITER command is supported in MySQL dumps,
+// and the data following a COPY ... FROM stdin statement in PostgreSQL dumps.
+type statementScanner struct {
+	r     *bufio.Reader
+	pg    bool
+	line  int
+	bytes int64
+	prev  byte
+	delim string
+	buf   strings.Builder
+	// copy reads the data of the COPY statement just returned, if any.
+	copy *copyReader
+	// words are the first words of a PostgreSQL statement, to find CREATE FUNCTION and PROCEDURE,
+	// and depth is the nesting of BEGIN and CASE in the body of one.
+	word  []byte
+	words []string
+	depth int
+}
+
+func newStatementScanner(r io.Reader, pg bool) *statementScanner {
+	return &statementScanner{
+		r:     bufio.NewReader(r),
+		pg:    pg,
+		line:  1,
+		delim: ";",
+	}
+}
+
+// readByte reads the next byte, keeping track of lines and bytes read.
+func (s *statementScanner) readByte() (byte, error) {
+	c, err := s.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	s.bytes++
+	if c == '\n' {
+		s.line++
+	}
+	return c, nil
+}
+
+// peek returns the next byte without consuming it.
+func (s *statementScanner) peek() (byte, bool) {
+	b, err := s.r.Peek(1)
+	if err != nil {
+		return 0, false
+	}
+	return b[0], true
+}
+
+// next returns the next statement without its terminating delimiter, and the line it starts on.
+// It returns io.EOF when there are no more statements.
+// The data of a COPY ... FROM stdin statement is skipped unless it was read from s.copy.
+func (s *statementScanner) next() (string, int, error) {
+	if s.copy != nil {
+		_, err := io.Copy(io.Discard, s.copy)
+		s.copy = nil
+		if err != nil {
+			return "", 0, err
+		}
+	}
+
+	s.buf.Reset()
+	s.words = s.words[:0]
+	s.depth = 0
+	start := 0
+	for {
+		c, err := s.readByte()
+		if s.pg && len(s.word) > 0 && (err != nil || !isIdent(c)) {
+			s.endWord()
+		}
+
+		if err == io.EOF {
+			stmt := strings.TrimSpace(s.buf.String())
+			if stmt == "" {
+				return "", 0, io.EOF
+			}
+			return stmt, start, nil
+		}
+
+		if err != nil {
+			return "", 0, err
+		}
+
+		if start == 0 && !isSpace(c) {
+			start = s.line
+		}
+
+		switch {
+		case (c == 'D' || c == 'd') && !s.pg && strings.TrimSpace(s.buf.String()) == "" && s.isDelimiterCommand():
+			err = s.delimiterCommand()
+			start = 0
+			if err == nil {
+				continue
+			}
+		case c == s.delim[0] && s.depth > 0:
+			s.buf.WriteByte(c)
+		case c == s.delim[0] && s.atDelimiter():
+			stmt := strings.TrimSpace(s.buf.String())
+			if stmt == "" {
+				start = 0
+				continue
+			}
+			s.prev = c
+			if s.pg && copyFromStdinRE.MatchString(stmt) {
+				s.copy = &copyReader{s: s}
+			}
+			return stmt, start, nil
+		case c == '\'':
+			escapes := !s.pg || s.prev == 'E' || s.prev == 'e'
+			err = s.quoted(c, escapes)
+		case c == '"':
+			err = s.quoted(c, !s.pg)
+		case c == '`' && !s.pg:
+			err = s.quoted(c, false)
+		case c == '-' && s.isLineComment():
+			err = s.lineComment()
+			if s.buf.Len() == 0 {
+				start = 0
+			}
+			continue
+		case c == '/' && s.is('*'):
+			err = s.blockComment()
+		case c == '$' && s.pg && !isIdent(s.prev):
+			err = s.dollarQuoted()
+		default:
+			s.buf.WriteByte(c)
+			if s.pg && isIdent(c) {
+				s.word = append(s.word, c)
+			}
+		}
+
+		if err == io.EOF {
+			stmt := strings.TrimSpace(s.buf.String())
+			if stmt == "" {
+				return "", 0, io.EOF
+			}
+			return stmt, start, nil
+		}
+
+		if err != nil {
+			return "", 0, err
+		}
+		s.prev = c
+	}
+}
+
+// endWord counts the word just read, tracking BEGIN ... END blocks in the body of a function or procedure.
+func (s *statementScanner) endWord() {
+	w := strings.ToUpper(string(s.word))
+	s.word = s.word[:0]
+	if len(s.words) < 4 {
+		s.words = append(s.words, w)
+		return
+	}
+
+	if !s.isRoutine() {
+		return
+	}
+
+	switch {
+	case w == "BEGIN" || (w == "CASE" && s.depth > 0):
+		s.depth++
+	case w == "END" && s.depth > 0:
+		s.depth--
+	}
+}
+
+// isRoutine reports whether the statement creates a function or procedure.
+func (s *statementScanner) isRoutine() bool {
+	w := s.words
+	if w[0] != "CREATE" {
+		return false
+	}
+
+	kind := w[1]
+	if w[1] == "OR" && w[2] == "REPLACE" {
+		kind = w[3]
+	}
+	return kind == "FUNCTION" || kind == "PROCEDURE"
+}
+
+// readLine reads the rest of the current line, including the newline.
+func (s *statementScanner) readLine() (string, error) {
+	line, err := s.r.ReadString('\n')
+	s.bytes += int64(len(line))
+	if strings.HasSuffix(line, "\n") {
+		s.line++
+	}
+	return line, err
+}
+
+// isDelimiterCommand reports whether a 'D' just read at the start of a statement starts a DELIMITER command.
+func (s *statementScanner) isDelimiterCommand() bool {
+	b, _ := s.r.Peek(9)
+	return len(b) == 9 && strings.EqualFold(string(b[:8]), "ELIMITER") && isSpace(b[8]) && b[8] != '\n'
+}
+
+// delimiterCommand reads the new delimiter from the rest of the line.
+func (s *statementScanner) delimiterCommand() error {
+	var line strings.Builder
+	for {
+		c, err := s.readByte()
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if err == io.EOF || c == '\n' {
+			fields := strings.Fields(line.String())
+			if len(fields) > 1 {
+				s.delim = fields[1]
+			}
+			return err
+		}
+		line.WriteByte(c)
+	}
+}
+
+// atDelimiter reports whether the byte just read starts the delimiter, and consumes the rest of it.
+func (s *statementScanner) atDelimiter() bool {
+	if len(s.delim) == 1 {
+		return true
+	}
+
+	b, err := s.r.Peek(len(s.delim) - 1)
+	if err != nil || string(b) != s.delim[1:] {
+		return false
+	}
+
+	for range b {
+		s.readByte()
+	}
+	return true
+}
+
+// is reports whether the next byte is c.
+func (s *statementScanner) is(c byte) bool {
+	n, ok := s.peek()
+	return ok && n == c
+}
+
+// isLineComment reports whether a '-' just read starts a comment.
+// MySQL requires whitespace after the double dash.
+func (s *statementScanner) isLineComment() bool {
+	b, err := s.r.Peek(2)
+	if len(b) == 0 || b[0] != '-' {
+		return false
+	}
+
+	if s.pg || err != nil {
+		return true
+	}
+	return isSpace(b[1])
+}
+
+// lineComment discards input up to the end of the line.
+func (s *statementScanner) lineComment() error {
+	for {
+		c, err := s.readByte()
+		if err != nil {
+			return err
+		}
+
+		if c == '\n' {
+			if s.buf.Len() > 0 {
+				s.buf.WriteByte(c)
+			}
+			return nil
+		}
+	}
+}
+
+// blockComment copies a /* ... */ comment into the statement.
+func (s *statementScanner) blockComment() error {
+	s.readByte()
+	s.buf.WriteString("/*")
+	var last byte
+	for {
+		c, err := s.readByte()
+		if err != nil {
+			return err
+		}
+
+		s.buf.WriteByte(c)
+		if last == '*' && c == '/' {
+			return nil
+		}
+		last = c
+	}
+}
+
+// quoted copies a quoted string or identifier into the statement.
+// A doubled quote character is part of the value. Backslash escapes are honoured if escapes is true.
+func (s *statementScanner) quoted(q byte, escapes bool) error {
+	s.buf.WriteByte(q)
+	for {
+		c, err := s.readByte()
+		if err != nil {
+			return err
+		}
+
+		s.buf.WriteByte(c)
+		switch {
+		case c == '\\' && escapes:
+			c, err = s.readByte()
+			if err != nil {
+				return err
+			}
+			s.buf.WriteByte(c)
+		case c == q:
+			if !s.is(q) {
+				return nil
+			}
+			c, _ = s.readByte()
+			s.buf.WriteByte(c)
+		}
+	}
+}
+
+// dollarQuoted copies a PostgreSQL $tag$ ... $tag$ string into the statement.
+// A '$' which doesn't start a valid tag is copied as is.
+func (s *statementScanner) dollarQuoted() error {
+	tag := []byte{'$'}
+	for {
+		c, ok := s.peek()
+		if !ok {
+			s.buf.Write(tag)
+			return nil
+		}
+
+		if c == '$' {
+			s.readByte()
+			tag = append(tag, c)
+			break
+		}
+
+		if !isIdent(c) || (len(tag) == 1 && c >= '0' && c <= '9') {
+			s.buf.Write(tag)
+			return nil
+		}
+
+		s.readByte()
+		tag = append(tag, c)
+	}
+
+	s.buf.Write(tag)
+	end := string(tag)
+	body := s.buf.Len()
+	for {
+		c, err := s.readByte()
+		if err != nil {
+			return err
+		}
+
+		s.buf.WriteByte(c)
+		if c == '$' && s.buf.Len()-body >= len(end) && strings.HasSuffix(s.buf.String(), end) {
+			return nil
+		}
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c >= 0x80
+}
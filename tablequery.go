@@ -0,0 +1,96 @@
+package sqldump
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// TableQuery selects the rows dumped from a table, for partial dumps like the orders of the last 30 days.
+type TableQuery struct {
+	// Where is an SQL condition on the rows, like "tenant_id = 42".
+	Where string
+	// OrderBy sorts the rows, like "created_at DESC".
+	OrderBy string
+	// Limit is the maximum number of rows. 0 dumps every matching row.
+	Limit int64
+}
+
+// SetTableQuery selects the rows dumped from a table, named as for Dump().
+// The clauses are written into the data queries as they are, so they must not come from untrusted input.
+// Tables with an ORDER BY or limit are read with a single query instead of in pages.
+func (d *Dumper) SetTableQuery(name string, q TableQuery) {
+	if d.tableQueries == nil {
+		d.tableQueries = map[string]TableQuery{}
+	}
+	d.tableQueries[name] = q
+}
+
+// paged reports whether the rows can be read in pages ordered by the table key.
+func (q TableQuery) paged() bool {
+	return q.OrderBy == "" && q.Limit == 0
+}
+
+// clauses returns the query clauses after the table name, with the condition in parentheses.
+func (q TableQuery) clauses() string {
+	var s string
+	if q.Where != "" {
+		s += " WHERE (" + q.Where + ")"
+	}
+
+	if q.OrderBy != "" {
+		s += " ORDER BY " + q.OrderBy
+	}
+
+	if q.Limit > 0 {
+		s += " LIMIT " + strconv.FormatInt(q.Limit, 10)
+	}
+	return s
+}
+
+// String describes the selection on one line for the dump comments.
+func (q TableQuery) String() string {
+	var parts []string
+	if q.Where != "" {
+		parts = append(parts, "WHERE "+q.Where)
+	}
+
+	if q.OrderBy != "" {
+		parts = append(parts, "ORDER BY "+q.OrderBy)
+	}
+
+	if q.Limit > 0 {
+		parts = append(parts, "LIMIT "+strconv.FormatInt(q.Limit, 10))
+	}
+	return strings.Join(strings.Fields(strings.Join(parts, " ")), " ")
+}
+
+// resolveTableQueries keys the table queries by the names in the table list.
+// PostgreSQL names are resolved like those given to Dump, so a bare name applies in every dumped schema.
+func (d *Dumper) resolveTableQueries(tables []pgName, mytables []string) error {
+	d.queries = nil
+	if len(d.tableQueries) == 0 {
+		return nil
+	}
+
+	d.queries = make(map[string]TableQuery, len(d.tableQueries))
+	for name, q := range d.tableQueries {
+		found := false
+		for _, t := range tables {
+			if t.matches(name) {
+				d.queries[t.Qualified] = q
+				found = true
+			}
+		}
+
+		if contains(mytables, name) {
+			d.queries[name] = q
+			found = true
+		}
+
+		if !found {
+			return errors.New("No table named " + name + ".")
+		}
+	}
+	return nil
+}
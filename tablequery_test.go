@@ -0,0 +1,115 @@
+package sqldump
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestTableQueryString(t *testing.T) {
+	q := TableQuery{Where: "created_at >\n  now() - interval '30 days'", OrderBy: "id DESC", Limit: 10}
+	if expected := "WHERE created_at > now() - interval '30 days' ORDER BY id DESC LIMIT 10"; q.String() != expected {
+		t.Errorf("expected %#v, got %#v", expected, q.String())
+	}
+
+	if q.paged() || !(TableQuery{Where: "a = 1"}).paged() {
+		t.Errorf("expected only tables without ORDER BY or limit to be read in pages")
+	}
+}
+
+func TestCreateTableValuesTableQuery(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	keyRows := sqlmock.NewRows([]string{"INDEX_NAME", "COLUMN_NAME", "NULLABLE"}).AddRow("PRIMARY", "id", true)
+	mock.ExpectQuery(MY_TABLE_KEY).WithArgs("orders").WillReturnRows(keyRows)
+	mock.ExpectQuery("SELECT * FROM `orders` WHERE (tenant_id = 42 OR tenant_id = 43) ORDER BY `id` LIMIT 2").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(5))
+	mock.ExpectQuery("SELECT * FROM `orders` WHERE (tenant_id = 42 OR tenant_id = 43) AND `id` > ? ORDER BY `id` LIMIT 2").
+		WithArgs("5").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+
+	mock.ExpectQuery(MY_TABLE_KEY).WithArgs("orders").WillReturnRows(
+		sqlmock.NewRows([]string{"INDEX_NAME", "COLUMN_NAME", "NULLABLE"}).AddRow("PRIMARY", "id", true))
+	mock.ExpectQuery("SELECT * FROM `orders` WHERE (tenant_id = 42 OR tenant_id = 43) ORDER BY id DESC LIMIT 3").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7).AddRow(5).AddRow(1))
+
+	d := NewStreamDumper(db)
+	d.SetMaxRows(2)
+	d.queries = map[string]TableQuery{"orders": {Where: "tenant_id = 42 OR tenant_id = 43"}}
+	result, err := collectTableValues(d, "orders")
+	if err != nil {
+		t.Fatalf("error was not expected while reading values: %s", err)
+	}
+
+	if expected := "('1'),('5'),('7')"; result != expected {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+
+	d.queries["orders"] = TableQuery{Where: "tenant_id = 42 OR tenant_id = 43", OrderBy: "id DESC", Limit: 3}
+	if _, err = collectTableValues(d, "orders"); err != nil {
+		t.Fatalf("error was not expected while reading values: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestDumpMySQLTableQuery(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectQuery("^SELECT version()").WillReturnRows(sqlmock.NewRows([]string{"Version()"}).AddRow("test_version"))
+	mock.ExpectQuery("^SELECT @@max_allowed_packet$").WillReturnRows(maxPacketRows())
+	mock.ExpectQuery("^SHOW FULL TABLES$").WillReturnRows(fullTablesRows("orders"))
+	mock.ExpectQuery("FROM information_schema.TRIGGERS").WillReturnRows(noTriggerRows())
+	mock.ExpectQuery("^SHOW CREATE TABLE `orders`$").WillReturnRows(sqlmock.NewRows([]string{"Table", "Create Table"}).
+		AddRow("orders", "CREATE TABLE `orders` (`id` int(11) NOT NULL)"))
+	mock.ExpectQuery("FROM information_schema.STATISTICS").WithArgs("orders").WillReturnRows(noKeyRows())
+	mock.ExpectQuery(`^SELECT \* FROM ` + "`orders`" + ` WHERE \(tenant_id = 42\) LIMIT 5$`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	dumper := NewStreamDumper(db)
+	dumper.SetTableQuery("orders", TableQuery{Where: "tenant_id = 42", Limit: 5})
+	var buf bytes.Buffer
+	if err := dumper.DumpTo(&buf); err != nil {
+		t.Fatalf("Error while dumping the database: %s", err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	expected := "-- Dumping data for table `orders`\n-- Rows: WHERE tenant_id = 42 LIMIT 5\n--\n"
+	if !strings.Contains(buf.String(), expected) {
+		t.Fatalf("expected output to contain %#v, got %#v", expected, buf.String())
+	}
+}
+
+func TestResolveTableQueries(t *testing.T) {
+	d := NewStreamDumper(nil)
+	d.SetTableQuery("orders", TableQuery{Limit: 1})
+	tables := []pgName{{"app", "orders", "app.orders"}, {"public", "orders", "public.orders"}}
+	if err := d.resolveTableQueries(tables, nil); err != nil {
+		t.Fatalf("error was not expected while resolving table queries: %s", err)
+	}
+
+	if len(d.queries) != 2 || d.queries["app.orders"].Limit != 1 || d.queries["public.orders"].Limit != 1 {
+		t.Fatalf("expected the query for both schemas, got %#v", d.queries)
+	}
+
+	d.SetTableQuery("missing", TableQuery{Limit: 1})
+	if err := d.resolveTableQueries(tables, nil); err == nil {
+		t.Fatalf("expected an error for an unknown table")
+	}
+}